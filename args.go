@@ -0,0 +1,1100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMessage is the summary of WFH events when default_message isn't set.
+	defaultMessage       = "WFH"
+	defaultOfficeMessage = "Office"
+	defaultBackfillDays  = 5
+	// defaultMaxFutureDays catches typos like 2204-06-04, while allowing bookings a year ahead.
+	defaultMaxFutureDays = 365
+	// defaultMarkerDuration is how long -at events last when marker_duration isn't set.
+	defaultMarkerDuration = 15 * time.Minute
+)
+
+// options holds the command line. Dates and the message depend on the config, they
+// are filled in by resolve.
+type options struct {
+	list       bool
+	office     bool
+	force      bool
+	date       time.Time
+	message    string
+	appendNote string
+	backfill   bool
+	revoke     bool
+	from       time.Time
+	to         time.Time
+	limit      int
+	last       int
+	thisWeek   bool
+	nextWeek   bool
+	sort       string
+	reverse    bool
+	weekdays   bool
+	conflicts  bool
+	dryRun     bool
+	update     bool
+	color      int
+	colorGiven bool
+	// colorFromMessage hashes the message to a color, see Config.messageColor.
+	colorFromMessage bool
+	description      string
+	calendarID       string
+	calStatus        bool
+	reminders        []Reminder
+	month            string
+	isMonth          bool
+	noEmoji          bool
+	verbose          bool
+	sync             bool
+	offline          bool
+	recolor          bool
+	migrate          string
+	archive          bool
+	toCalendar       string
+	restoreFile      string
+	importFile       string
+	batchFile        string
+	// taskPattern selects the tasks to book from, for -from-google-tasks.
+	taskPattern   *regexp.Regexp
+	taskList      string
+	completeTasks bool
+	previewLink   bool
+	colorLegend   bool
+	booker        string
+	properties    map[string]string
+	out           string
+	export        string
+	raw           bool
+	sanitize      bool
+	noDeclined    bool
+	noPager       bool
+	query         string
+	metrics       string
+	authMode      string
+	callbackPorts []int
+	forceRefresh  bool
+	clearToday    bool
+	deleteID      string
+	noNotify      bool
+	failFast      bool
+	explain       bool
+	repeatLast    bool
+	validateCred  bool
+	tokenInfo     bool
+	serve         string
+	plan          bool
+	printConfig   bool
+	undoLastN     int
+	// startTime is the time of day timed events start at, timed says whether it's set.
+	startTime time.Time
+	timed     bool
+	// marker says the timed event is a short -at marker rather than working hours.
+	marker   bool
+	duration time.Duration
+	// every holds the days of the week of a recurring booking, with count or until
+	// ending it.
+	every        []time.Weekday
+	count        int
+	until        time.Time
+	untilArg     string
+	recurrence   []string
+	attach       string
+	attachTitle  string
+	transparency string
+	location     string
+
+	calendarArg string
+	dateArg     string
+	fromArg     string
+	toArg       string
+	messageArg  string
+}
+
+// parseArgs parses the command line. defaults, from the config, replace the built-in
+// defaults of the flags. The rest of the config isn't needed, so -help works even if
+// there is none.
+func parseArgs(defaults map[string]any) (options, error) {
+	// Define flags for the date and message arguments with default values of empty strings.
+	dateFlag := flag.String("date", "", "Provide a date in the format YYYY-MM-DD, today, tomorrow, yesterday or +N/-N days, or a week as YYYY-Www")
+	messageFlag := flag.String("message", "", "Provide a custom message")
+	list := flag.Bool("list", false, "List all events")
+	office := flag.Bool("office", false, "Mark the day as an office day, removing any WFH event")
+	deleteID := flag.String("delete-id", "", "Delete the event with this ID, as shown by -list -verbose")
+	clearToday := flag.Bool("clear-today", false, "Delete today's WFH events")
+	force := flag.Bool("force", false, "Don't ask for confirmation")
+	appendNote := flag.String("append-note", "", "Append a timestamped note to the day's WFH event")
+	backfill := flag.Bool("backfill", false, "Offer to book WFH on recent working days without a booking. Unlike other bookings, past days need no -force")
+	revoke := flag.Bool("revoke", false, "Revoke the stored token with Google and delete it")
+	fromFlag := flag.String("from", "", "List from this date (YYYY-MM-DD), defaults to -date")
+	toFlag := flag.String("to", "", "List up to and including this date (YYYY-MM-DD), defaults to -from")
+	limit := flag.Int("limit", 0, "Show at most this many events when listing, 0 means no limit")
+	last := flag.Int("last", 0, "List from this many days ago through today, instead of -from and -to")
+	thisWeek := flag.Bool("thisweek", false, "List this week, Monday to Sunday, instead of -from and -to")
+	nextWeek := flag.Bool("nextweek", false, "List next week, Monday to Sunday, instead of -from and -to")
+	sortFlag := flag.String("sort", wfh.OrderStartTime, "Sort listings by startTime or updated")
+	reverse := flag.Bool("reverse", false, "List the most recent events first")
+	weekdays := flag.Bool("weekday-summary", false, "Count WFH events per weekday between -from and -to")
+	conflicts := flag.Bool("conflict-report", false, "List the days between -from and -to with both a WFH event and an event matching conflict_keywords")
+	dryRun := flag.Bool("dry-run", false, "Print the event instead of booking it, or the changes -update would make")
+	update := flag.Bool("update", false, "Update the day's WFH event with -message, -color and -description")
+	color := flag.Int("color", 0, "Color ID (1-11) of the event, 0 picks a random color")
+	colorFromMessage := flag.Bool("color-from-message", false, "Give the event a color hashed from its message, so each message keeps its color")
+	description := flag.String("description", "", "Description of the event")
+	location := flag.String("location", "", "Location of the event, like Home or a city. Defaults to location")
+	booker := flag.String("booker", "", "Who the event is booked for, shown in listings instead of the account creating it")
+	properties := make(propertiesFlag)
+	flag.Var(properties, "prop", "Store key=value as a private extended property of the event, for other tools to read. May be repeated")
+	calendarFlag := flag.String("calendar", "", "Calendar name from the config, or a calendar ID. Defaults to calendar_id")
+	primary := flag.Bool("primary", false, "Use your primary calendar, same as -calendar primary")
+	calStatus := flag.Bool("all-calendars-status", false, "Show whether each configured calendar has a WFH event on -date")
+	var month monthFlag
+	flag.Var(&month, "month", "Count WFH days in this month (YYYY-MM), defaults to the current month")
+	sync := flag.Bool("sync", false, "Refresh the local event cache between -from and -to, defaults to the current month")
+	offline := flag.Bool("offline", false, "Read listings from the local event cache instead of the calendar")
+	archive := flag.Bool("archive", false, "Move the WFH events between -from and -to to -to-calendar")
+	toCalendar := flag.String("to-calendar", "", "Calendar name from the config, or a calendar ID, that -archive moves events to")
+	restoreFile := flag.String("restore", "", "Create the events in a backup from ~/.wfh/backups again")
+	migrate := flag.String("migrate-summary", "", "Tag the events between -from and -to with this summary as WFH events, for events booked before wfh tagged them")
+	recolor := flag.Bool("recolor", false, "Change the color of the WFH events between -from and -to to -color")
+	batchFile := flag.String("batch", "", "Run the book and delete operations in a JSON file, in order")
+	fromTasks := flag.String("from-google-tasks", "", "Book the days of the open Google Tasks whose title matches this regular expression, like ^WFH")
+	taskList := flag.String("task-list", "@default", "ID of the Google Tasks list to read -from-google-tasks from")
+	completeTasks := flag.Bool("complete-tasks", false, "Mark the tasks -from-google-tasks booked as done")
+	importFile := flag.String("import", "", "Book a WFH day for each event in an iCalendar (.ics) file")
+	colorLegend := flag.Bool("color-legend", false, "Show the color IDs and what they look like")
+	previewLink := flag.Bool("preview-link", false, "Print a Google Calendar link to create the event in the browser, instead of booking it")
+	free := flag.Bool("free", false, "Don't block time with the event, so meeting finders see you as free")
+	busy := flag.Bool("busy", false, "Block time with the event")
+	pasteCode := flag.Bool("paste-code", false, "When logging in, paste the code from the browser instead of receiving it on localhost:8066")
+	oob := flag.Bool("oob", false, "When logging in to Microsoft, use the out-of-band redirect and paste the code, without localhost")
+	metricsFlag := flag.String("metrics", "", "Write Prometheus metrics about the calendar API calls to this file, or - for stdout when wfh exits")
+	out := flag.String("out", "", "Write listings to this file instead of stdout")
+	query := flag.String("q", "", "Only list events mentioning this text, searched for by the calendar")
+	noPager := flag.Bool("no-pager", false, "Don't page long listings through $PAGER")
+	noDeclined := flag.Bool("exclude-declined", false, "Leave out events you've declined")
+	raw := flag.Bool("raw", false, "List the events as JSON, with every field the calendar returns")
+	sanitize := flag.Bool("sanitize", false, "Hide who created events and the summaries of events that aren't WFH, for screen sharing")
+	export := flag.String("export", "", "List the events as a table to share instead, md for Markdown")
+	verbose := flag.Bool("verbose", false, "Show event IDs, colors and visibility when listing")
+	tokenInfo := flag.Bool("token-info", false, "Show whether the stored token is valid, when it expires and whether it can be refreshed")
+	validateCred := flag.Bool("validate-credentials", false, "Check the embedded OAuth client credentials")
+	startTime := flag.String("start-time", "", "Book a timed event starting at this time of day, HH:MM, instead of an all-day one")
+	at := flag.String("at", "", "Book a short timed event at this time of day, HH:MM, to mark when WFH starts")
+	duration := flag.Duration("duration", 0, "How long the -start-time or -at event lasts, like 8h. Defaults to default_duration or marker_duration")
+	every := flag.String("every", "", "Book a recurring event on these days of the week, like MO,WE,FR")
+	count := flag.Int("count", 0, "End the -every recurrence after this many events")
+	until := flag.String("until", "", "End the -every recurrence on this date, YYYY-MM-DD")
+	printConfigFlag := flag.Bool("print-config", false, "Print the config as wfh sees it, with secrets redacted, and the files it uses")
+	forceRefresh := flag.Bool("force-refresh", false, "Get a new access token before doing anything else")
+	undoLastN := flag.Int("undo-last-n", 0, "Delete the last N events wfh created, after confirming")
+	planFlag := flag.Bool("plan", false, "Show the next 7 working days and whether they're booked, and toggle them")
+	serveAddr := flag.String("serve", "", "Serve /healthz and POST /book over HTTP on this address, like localhost:8080")
+	repeatLast := flag.Bool("repeat-last", false, "Book the same message, color and calendar as last time, on -date or tomorrow")
+	explain := flag.Bool("explain", false, "Describe the booking in words and, on a terminal, ask before booking")
+	failFast := flag.Bool("fail-fast", false, "When booking several days, stop at the first one that fails")
+	noNotify := flag.Bool("no-notify", false, "Don't tell slack_webhook_url or webhook about the booking")
+	noEmoji := flag.Bool("no-emoji", false, "Don't put summary_emoji in front of the message")
+	attach := flag.String("attach", "", "Attach a Google Drive file to the event, by its link")
+	attachTitle := flag.String("attach-title", "", "Title of the -attach file in the event")
+	remind := flag.Int64("remind", 0, "Add a popup reminder this many minutes before the event, instead of the configured reminders")
+
+	err := applyDefaults(defaults)
+	if err != nil {
+		return options{}, err
+	}
+
+	// Parse the flags
+	flag.Usage = usage
+	flag.Parse()
+	// -month works without a value, so "-month 2024-06" leaves the month as an argument.
+	if month.set && month.value == "" && flag.NArg() > 0 {
+		err := month.Set(flag.Arg(0))
+		if err != nil {
+			return options{}, err
+		}
+		// the command line exits on errors, there is nothing to check.
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	// Check if there are any non-flag arguments and fail if there are
+	if len(flag.Args()) > 0 {
+		return options{}, fmt.Errorf("unexpected non-flag arguments detected")
+	}
+
+	opts := options{
+		list:          *list,
+		office:        *office,
+		clearToday:    *clearToday,
+		deleteID:      strings.TrimSpace(*deleteID),
+		force:         *force,
+		appendNote:    *appendNote,
+		backfill:      *backfill,
+		revoke:        *revoke,
+		limit:         *limit,
+		last:          *last,
+		thisWeek:      *thisWeek,
+		nextWeek:      *nextWeek,
+		sort:          *sortFlag,
+		reverse:       *reverse,
+		weekdays:      *weekdays,
+		conflicts:     *conflicts,
+		dryRun:        *dryRun,
+		update:        *update,
+		color:         *color,
+		description:   *description,
+		location:      *location,
+		booker:        *booker,
+		properties:    properties,
+		out:           *out,
+		export:        *export,
+		raw:           *raw,
+		sanitize:      *sanitize,
+		noDeclined:    *noDeclined,
+		noPager:       *noPager,
+		query:         *query,
+		metrics:       *metricsFlag,
+		calStatus:     *calStatus,
+		calendarArg:   *calendarFlag,
+		month:         month.value,
+		isMonth:       month.set,
+		noEmoji:       *noEmoji,
+		noNotify:      *noNotify,
+		failFast:      *failFast,
+		explain:       *explain,
+		repeatLast:    *repeatLast,
+		validateCred:  *validateCred,
+		tokenInfo:     *tokenInfo,
+		serve:         *serveAddr,
+		plan:          *planFlag,
+		printConfig:   *printConfigFlag,
+		undoLastN:     *undoLastN,
+		forceRefresh:  *forceRefresh,
+		duration:      *duration,
+		count:         *count,
+		untilArg:      *until,
+		attach:        *attach,
+		attachTitle:   *attachTitle,
+		verbose:       *verbose,
+		sync:          *sync,
+		offline:       *offline,
+		recolor:       *recolor,
+		migrate:       strings.TrimSpace(*migrate),
+		archive:       *archive,
+		toCalendar:    strings.TrimSpace(*toCalendar),
+		restoreFile:   *restoreFile,
+		importFile:    *importFile,
+		batchFile:     *batchFile,
+		taskList:      *taskList,
+		completeTasks: *completeTasks,
+		previewLink:   *previewLink,
+		colorLegend:   *colorLegend,
+		dateArg:       *dateFlag,
+		fromArg:       *fromFlag,
+		toArg:         *toFlag,
+		messageArg:    *messageFlag,
+	}
+	if opts.limit < 0 {
+		return options{}, fmt.Errorf("-limit must not be negative")
+	}
+	if opts.undoLastN < 0 {
+		return options{}, fmt.Errorf("-undo-last-n must not be negative")
+	}
+	if opts.last < 0 {
+		return options{}, fmt.Errorf("-last must not be negative")
+	}
+	err = checkColor(opts.color)
+	if err != nil {
+		return options{}, fmt.Errorf("-color: %w", err)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	err = checkConflicts(set)
+	if err != nil {
+		return options{}, err
+	}
+	if set["migrate-summary"] && opts.migrate == "" {
+		return options{}, fmt.Errorf("-migrate-summary needs the summary of the events to tag")
+	}
+	if opts.archive && opts.toCalendar == "" {
+		return options{}, fmt.Errorf("-archive needs -to-calendar")
+	}
+	if set["delete-id"] && opts.deleteID == "" {
+		return options{}, fmt.Errorf("-delete-id needs an event ID")
+	}
+	if *fromTasks != "" {
+		// task titles are typed by hand, so case doesn't matter.
+		opts.taskPattern, err = regexp.Compile("(?i)" + *fromTasks)
+		if err != nil {
+			return options{}, fmt.Errorf("-from-google-tasks: %w", err)
+		}
+	} else if set["from-google-tasks"] {
+		return options{}, fmt.Errorf("-from-google-tasks needs a pattern for the task titles")
+	}
+	// -color beats colors_by_weekday, a color from defaults doesn't.
+	opts.colorGiven = set["color"]
+	if set["color"] && *colorFromMessage {
+		return options{}, fmt.Errorf("-color and -color-from-message can't be combined")
+	}
+	opts.colorFromMessage = *colorFromMessage
+	if *startTime != "" {
+		opts.startTime, err = time.Parse("15:04", *startTime)
+		if err != nil {
+			return options{}, fmt.Errorf("-start-time must be HH:MM: %w", err)
+		}
+		opts.timed = true
+	}
+	if *at != "" {
+		if opts.timed {
+			return options{}, fmt.Errorf("-at and -start-time can't be combined")
+		}
+		opts.startTime, err = time.Parse("15:04", *at)
+		if err != nil {
+			return options{}, fmt.Errorf("-at must be HH:MM: %w", err)
+		}
+		opts.timed = true
+		opts.marker = true
+	}
+	if set["duration"] && !opts.timed {
+		return options{}, fmt.Errorf("-duration needs -start-time or -at")
+	}
+	if opts.duration != 0 {
+		err = checkDuration(opts.duration)
+		if err != nil {
+			return options{}, fmt.Errorf("-duration: %w", err)
+		}
+	}
+	if *every != "" {
+		opts.every, err = parseEvery(*every)
+		if err != nil {
+			return options{}, fmt.Errorf("-every: %w", err)
+		}
+		if (opts.count == 0) == (opts.untilArg == "") {
+			return options{}, fmt.Errorf("-every needs either -count or -until")
+		}
+		if opts.count < 0 {
+			return options{}, fmt.Errorf("-count must not be negative")
+		}
+	} else if set["count"] || set["until"] {
+		return options{}, fmt.Errorf("-count and -until need -every")
+	}
+	if *pasteCode && *oob {
+		return options{}, fmt.Errorf("-paste-code and -oob can't be combined")
+	}
+	if *pasteCode {
+		opts.authMode = authPaste
+	}
+	if *oob {
+		opts.authMode = authOOB
+	}
+	if *primary {
+		if opts.calendarArg != "" && opts.calendarArg != primaryCalendar {
+			return options{}, fmt.Errorf("-primary and -calendar can't be combined")
+		}
+		opts.calendarArg = primaryCalendar
+	}
+	if *free && *busy {
+		return options{}, fmt.Errorf("-free and -busy can't be combined")
+	}
+	if *free {
+		opts.transparency = "transparent"
+	}
+	if *busy {
+		opts.transparency = "opaque"
+	}
+	if opts.recolor && opts.color == 0 {
+		return options{}, fmt.Errorf("-recolor needs -color")
+	}
+	if set["remind"] || defaults["remind"] != nil {
+		opts.reminders = []Reminder{{Method: "popup", Minutes: *remind}}
+		err = opts.reminders[0].validate()
+		if err != nil {
+			return options{}, fmt.Errorf("-remind: %w", err)
+		}
+	}
+	if opts.export == "markdown" {
+		opts.export = "md"
+	}
+	if opts.export != "" && opts.export != "md" {
+		return options{}, fmt.Errorf("-export must be md, not %q", opts.export)
+	}
+	if opts.raw && (opts.export != "" || opts.sanitize || opts.verbose) {
+		// it's all there, as it came.
+		return options{}, fmt.Errorf("-raw can't be combined with -export, -sanitize or -verbose")
+	}
+	if opts.attachTitle != "" && opts.attach == "" {
+		return options{}, fmt.Errorf("-attach-title needs -attach")
+	}
+	if opts.attach != "" {
+		err = checkAttachment(opts.attach)
+		if err != nil {
+			return options{}, fmt.Errorf("-attach: %w", err)
+		}
+	}
+	if opts.sort != wfh.OrderStartTime && opts.sort != wfh.OrderUpdated {
+		return options{}, fmt.Errorf("-sort must be %s or %s", wfh.OrderStartTime, wfh.OrderUpdated)
+	}
+	return opts, nil
+}
+
+// checkHorizon refuses days further ahead than max_future_days, which are more likely
+// typos than plans.
+func checkHorizon(config Config, day time.Time) error {
+	maxDays := config.MaxFutureDays
+	if maxDays == 0 {
+		maxDays = defaultMaxFutureDays
+	}
+	limit := config.now().AddDate(0, 0, maxDays)
+	if day.After(limit) {
+		return fmt.Errorf("%s is more than %d days ahead, use -force if you mean it", day.Format("2006-01-02"), maxDays)
+	}
+	return nil
+}
+
+// checkNotice refuses past days, and days less than min_notice_days ahead, for
+// workplaces that want WFH booked in advance. -backfill, which only books past days,
+// isn't held to it.
+func checkNotice(config Config, day time.Time) error {
+	now := config.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if day.Before(today) {
+		return fmt.Errorf("%s is in the past, use -force to book anyway", day.Format("2006-01-02"))
+	}
+	earliest := today.AddDate(0, 0, config.MinNoticeDays)
+	if !day.Before(earliest) {
+		return nil
+	}
+	return fmt.Errorf("%s is less than %d day(s) ahead, min_notice_days only allows booking from %s on, use -force to book anyway",
+		day.Format("2006-01-02"), config.MinNoticeDays, earliest.Format("2006-01-02"))
+}
+
+// checkColor checks a color ID against the colors Google has. Zero is allowed, it
+// picks a random color.
+func checkColor(colorID int) error {
+	if colorID < 0 || colorID > wfh.MaxColorID {
+		return fmt.Errorf("color must be between 1 and %d, or 0 for a random color, not %d", wfh.MaxColorID, colorID)
+	}
+	return nil
+}
+
+// parseDate parses a YYYY-MM-DD date, or a date relative to now, in now's location.
+func parseDate(s string, now time.Time) (time.Time, error) {
+	if day, ok := parseRelativeDate(s, now); ok {
+		return day, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, now.Location())
+}
+
+// parseRelativeDate parses today, tomorrow, yesterday and +N/-N days from now, and
+// returns the start of that day. Days are counted on the calendar with AddDate rather
+// than as 24 hours, which would land on the wrong day around DST changes. It reports
+// false if s isn't a relative date.
+func parseRelativeDate(s string, now time.Time) (time.Time, bool) {
+	var days int
+	switch strings.ToLower(s) {
+	case "today":
+	case "tomorrow":
+		days = 1
+	case "yesterday":
+		days = -1
+	default:
+		if !strings.HasPrefix(s, "+") && !strings.HasPrefix(s, "-") {
+			return time.Time{}, false
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return time.Time{}, false
+		}
+		days = n
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, days), true
+}
+
+// parseEvery parses the days of the week for -every: iCalendar's two-letter codes,
+// like MO,WE,FR, or day names.
+func parseEvery(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		day, ok := parseWeekday(token)
+		if !ok {
+			for d := time.Sunday; d <= time.Saturday; d++ {
+				if strings.EqualFold(token, weekdayCode(d)) {
+					day, ok = d, true
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%q isn't a day of the week, use MO, TU, WE, TH, FR, SA or SU", token)
+		}
+		if !slices.Contains(days, day) {
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+// weekdayCode returns the iCalendar code for the day, like MO.
+func weekdayCode(day time.Weekday) string {
+	return strings.ToUpper(day.String()[:2])
+}
+
+// resolveRecurrence builds the RRULE for -every. The event starts on the first of the
+// days on or after -date, as the start always counts as an occurrence.
+func (opts *options) resolveRecurrence(config Config) error {
+	for !slices.Contains(opts.every, opts.date.Weekday()) {
+		opts.date = opts.date.AddDate(0, 0, 1)
+	}
+	opts.from, opts.to = opts.date, opts.date
+	codes := make([]string, 0, len(opts.every))
+	for _, day := range opts.every {
+		codes = append(codes, weekdayCode(day))
+	}
+	rule := "RRULE:FREQ=WEEKLY;BYDAY=" + strings.Join(codes, ",")
+	if opts.count > 0 {
+		rule += fmt.Sprintf(";COUNT=%d", opts.count)
+	} else {
+		var err error
+		opts.until, err = parseDate(opts.untilArg, config.now())
+		if err != nil {
+			return fmt.Errorf("invalid -until date: %w", err)
+		}
+		if opts.until.Before(opts.date) {
+			return fmt.Errorf("-until is before the first %s", opts.date.Weekday())
+		}
+		if !opts.force {
+			err := checkHorizon(config, opts.until)
+			if err != nil {
+				return fmt.Errorf("-until: %w", err)
+			}
+		}
+		// a date, as the events are all-day ones.
+		rule += ";UNTIL=" + opts.until.Format("20060102")
+	}
+	opts.recurrence = []string{rule}
+	return nil
+}
+
+// checkDuration checks the length of a timed event. It may run past midnight, but not
+// into a second day.
+func checkDuration(d time.Duration) error {
+	if d <= 0 || d > 24*time.Hour {
+		return fmt.Errorf("must be more than 0 and at most 24h, not %s", d)
+	}
+	return nil
+}
+
+// eventTimes returns when a timed event on the day starts and ends. Both are zero for
+// an all-day event. The end is counted in elapsed time, so an event across a DST change
+// still lasts as long as asked.
+func (opts options) eventTimes(day time.Time) (time.Time, time.Time) {
+	if !opts.timed {
+		return time.Time{}, time.Time{}
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), opts.startTime.Hour(), opts.startTime.Minute(), 0, 0, day.Location())
+	return start, start.Add(opts.duration)
+}
+
+// checkAttachment checks that the link is to a Google Drive file. Calendar only
+// attaches Drive files, and ignores anything else.
+func checkAttachment(link string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("url.Parse: %w", err)
+	}
+	if u.Scheme != "https" || (u.Host != "drive.google.com" && u.Host != "docs.google.com") {
+		return fmt.Errorf("%q isn't a Google Drive link, like https://drive.google.com/file/d/.../view", link)
+	}
+	return nil
+}
+
+// Google's limits on the extended properties of an event.
+const (
+	maxPropertyKey   = 44
+	maxPropertyValue = 1024
+	maxPropertySize  = 32 * 1024
+)
+
+// propertiesFlag collects the key=value pairs of -prop.
+type propertiesFlag map[string]string
+
+func (p propertiesFlag) String() string {
+	pairs := make([]string, 0, len(p))
+	for key, value := range p {
+		pairs = append(pairs, key+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p propertiesFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("%q must be key=value", s)
+	}
+	if key == wfh.MarkerKey || key == wfh.BookerKey {
+		return fmt.Errorf("%q is used by wfh itself", key)
+	}
+	if _, ok := p[key]; ok {
+		return fmt.Errorf("%q is given twice", key)
+	}
+	if n := utf8.RuneCountInString(key); n > maxPropertyKey {
+		return fmt.Errorf("key %q is %d characters, Google allows %d", key, n, maxPropertyKey)
+	}
+	if n := utf8.RuneCountInString(value); n > maxPropertyValue {
+		return fmt.Errorf("the value of %q is %d characters, Google allows %d", key, n, maxPropertyValue)
+	}
+	size := len(key) + len(value)
+	for k, v := range p {
+		size += len(k) + len(v)
+	}
+	if size > maxPropertySize {
+		return fmt.Errorf("the properties are %d bytes, Google allows %d for an event", size, maxPropertySize)
+	}
+	p[key] = value
+	return nil
+}
+
+// monthFlag is a flag that may be given with or without a YYYY-MM value.
+type monthFlag struct {
+	set   bool
+	value string
+}
+
+func (m *monthFlag) String() string {
+	return m.value
+}
+
+func (m *monthFlag) Set(s string) error {
+	m.set = true
+	// a bool flag without a value is set to "true".
+	if s == "true" {
+		return nil
+	}
+	_, err := time.Parse("2006-01", s)
+	if err != nil {
+		return fmt.Errorf("month must be YYYY-MM: %w", err)
+	}
+	m.value = s
+	return nil
+}
+
+// IsBoolFlag lets -month be given without a value.
+func (m *monthFlag) IsBoolFlag() bool {
+	return true
+}
+
+// parseISOWeek parses an ISO 8601 week like 2024-W23 and returns its Monday. It reports
+// false, without an error, if s doesn't look like a week at all.
+func parseISOWeek(s string, loc *time.Location) (time.Time, bool, error) {
+	var year, week int
+	n, err := fmt.Sscanf(s, "%4d-W%2d", &year, &week)
+	if err != nil || n != 2 || len(s) != len("2006-W01") {
+		return time.Time{}, false, nil
+	}
+	// week 1 is the week with the year's first Thursday in it, which always holds January 4th.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, loc)
+	daysSinceMonday := (int(jan4.Weekday()) + 6) % 7
+	monday := jan4.AddDate(0, 0, -daysSinceMonday+(week-1)*7)
+	// catches week 0, and week 53 in years that only have 52.
+	if y, w := monday.ISOWeek(); y != year || w != week {
+		return time.Time{}, true, fmt.Errorf("%d has no week %d", year, week)
+	}
+	return monday, true, nil
+}
+
+// normalizeSummary cleans up the message as normalize_summary says, so "wfh", "WFH"
+// and " WFH " end up the same.
+func (c Config) normalizeSummary(message string) string {
+	if c.NormalizeSummary == "" {
+		return message
+	}
+	words := strings.Fields(message)
+	for i, word := range words {
+		switch c.NormalizeSummary {
+		case "upper":
+			words[i] = strings.ToUpper(word)
+		case "lower":
+			words[i] = strings.ToLower(word)
+		case "title":
+			r, size := utf8.DecodeRuneInString(word)
+			words[i] = string(unicode.ToUpper(r)) + strings.ToLower(word[size:])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// withEmoji puts the emoji in front of the message, unless it's already there.
+func withEmoji(emoji, message string) string {
+	if emoji == "" || strings.HasPrefix(message, emoji) {
+		return message
+	}
+	return emoji + " " + message
+}
+
+// actionFlags select what wfh does. They are mutually exclusive; without any of them
+// wfh books a day.
+var actionFlags = []string{"list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "revoke", "update",
+	"all-calendars-status", "month", "sync",
+	"recolor", "migrate-summary", "archive", "import", "batch", "from-google-tasks", "preview-link", "color-legend", "clear-today", "delete-id", "validate-credentials", "serve", "plan", "undo-last-n", "restore", "print-config", "token-info"}
+
+// modifierFlags maps the flags that modify an action to the actions they apply to.
+// The empty string is booking.
+var modifierFlags = map[string][]string{
+	"date":               {"", "list", "office", "append-note", "update", "all-calendars-status", "month", "sync", "preview-link"},
+	"message":            {"", "office", "update", "preview-link", "migrate-summary", "from-google-tasks"},
+	"color":              {"", "office", "update", "recolor", "import", "from-google-tasks"},
+	"color-from-message": {"", "import", "batch", "from-google-tasks", "backfill", "serve", "plan"},
+	"description":        {"", "office", "update", "import", "preview-link", "from-google-tasks"},
+	"force":              {"", "office", "recolor", "migrate-summary", "import", "batch", "clear-today", "serve", "undo-last-n", "from-google-tasks", "plan", "archive"},
+	"dry-run":            {"", "office", "update", "import", "batch", "migrate-summary", "from-google-tasks", "archive", "restore"},
+	"from":               {"list", "weekday-summary", "conflict-report", "sync", "recolor", "migrate-summary", "archive"},
+	"to":                 {"list", "weekday-summary", "conflict-report", "sync", "recolor", "migrate-summary", "archive"},
+	"limit":              {"list"},
+	"last":               {"list", "weekday-summary", "conflict-report"},
+	"thisweek":           {"list", "weekday-summary", "conflict-report"},
+	"nextweek":           {"list", "weekday-summary", "conflict-report"},
+	"sort":               {"list"},
+	"reverse":            {"list"},
+	"remind":             {"", "office", "import"},
+	"every":              {""},
+	"count":              {""},
+	"until":              {""},
+	"start-time":         {""},
+	"at":                 {""},
+	"duration":           {""},
+	"attach":             {"", "import"},
+	"attach-title":       {"", "import"},
+	"no-emoji":           {"", "preview-link"},
+	"no-notify":          {"", "import", "batch", "backfill", "serve", "plan", "from-google-tasks"},
+	"repeat-last":        {""},
+	"task-list":          {"from-google-tasks"},
+	"complete-tasks":     {"from-google-tasks"},
+	"to-calendar":        {"archive"},
+	"fail-fast":          {"", "batch", "from-google-tasks"},
+	"explain":            {""},
+	"booker":             {"", "office", "import", "batch", "backfill", "serve", "plan", "from-google-tasks"},
+	"prop":               {"", "office", "import", "batch", "backfill", "serve", "plan", "from-google-tasks"},
+	"location":           {"", "office", "import", "batch", "backfill", "serve", "plan", "from-google-tasks"},
+	"free":               {"", "import"},
+	"busy":               {"", "import"},
+	"verbose":            {"list"},
+	"out":                {"list", "weekday-summary", "conflict-report", "month"},
+	"export":             {"list"},
+	"raw":                {"list"},
+	"sanitize":           {"list"},
+	"exclude-declined":   {"list"},
+	"no-pager":           {"list", "weekday-summary", "conflict-report", "month"},
+	"q":                  {"list"},
+	"metrics":            {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "all-calendars-status", "month", "sync", "recolor", "migrate-summary", "import", "batch", "clear-today", "delete-id", "serve", "plan", "undo-last-n", "from-google-tasks", "archive", "restore"},
+	"force-refresh":      {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "all-calendars-status", "month", "sync", "recolor", "migrate-summary", "import", "batch", "clear-today", "delete-id", "serve", "plan", "undo-last-n", "from-google-tasks", "archive", "restore"},
+	"paste-code":         {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "all-calendars-status", "month", "sync", "recolor", "migrate-summary", "import", "batch", "clear-today", "delete-id", "serve", "plan", "undo-last-n", "from-google-tasks", "archive", "restore"},
+	"oob":                {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "all-calendars-status", "month", "sync", "recolor", "migrate-summary", "import", "batch", "clear-today", "delete-id", "serve", "plan", "undo-last-n", "from-google-tasks", "archive", "restore"},
+	"offline":            {"list", "weekday-summary", "conflict-report", "month"},
+	"calendar":           {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "month", "sync", "recolor", "migrate-summary", "import", "batch", "preview-link", "clear-today", "delete-id", "serve", "plan", "print-config", "from-google-tasks", "archive"},
+	"primary":            {"", "list", "weekday-summary", "conflict-report", "office", "append-note", "backfill", "update", "month", "sync", "recolor", "migrate-summary", "import", "batch", "preview-link", "clear-today", "delete-id", "serve", "plan", "print-config", "from-google-tasks", "archive"},
+}
+
+// applyDefaults sets the flags to the defaults from the config. Precedence is built-in
+// default < config default < command line.
+func applyDefaults(defaults map[string]any) error {
+	for name, value := range defaults {
+		if slices.Contains(actionFlags, name) {
+			return fmt.Errorf("defaults: -%s selects what wfh does, it can't have a default", name)
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("defaults: unknown flag -%s", name)
+		}
+		// unlike flag.Set, this doesn't count as given on the command line, so defaults
+		// for flags that don't apply to the action are ignored instead of conflicting.
+		err := f.Value.Set(fmt.Sprint(value))
+		if err != nil {
+			return fmt.Errorf("defaults: -%s: %w", name, err)
+		}
+		f.DefValue = fmt.Sprint(value)
+	}
+	return nil
+}
+
+// checkConflicts returns an error naming the flags if the set flags can't be combined.
+func checkConflicts(set map[string]bool) error {
+	action := ""
+	for _, name := range actionFlags {
+		if !set[name] {
+			continue
+		}
+		if action != "" {
+			return fmt.Errorf("-%s and -%s can't be combined", action, name)
+		}
+		action = name
+	}
+	names := make([]string, 0, len(modifierFlags))
+	for name := range modifierFlags {
+		names = append(names, name)
+	}
+	// sorted, so the error is the same every time.
+	slices.Sort(names)
+	for _, name := range names {
+		if set[name] && !slices.Contains(modifierFlags[name], action) {
+			if action == "" {
+				return fmt.Errorf("-%s can't be used when booking", name)
+			}
+			return fmt.Errorf("-%s can't be used with -%s", name, action)
+		}
+	}
+	if set["date"] && (set["from"] || set["to"]) {
+		return fmt.Errorf("-date can't be combined with -from/-to")
+	}
+	if set["last"] && (set["date"] || set["from"] || set["to"]) {
+		return fmt.Errorf("-last can't be combined with -date or -from/-to")
+	}
+	for _, week := range []string{"thisweek", "nextweek"} {
+		if set[week] && (set["date"] || set["from"] || set["to"] || set["last"]) {
+			return fmt.Errorf("-%s can't be combined with -date, -from/-to or -last", week)
+		}
+	}
+	if set["thisweek"] && set["nextweek"] {
+		return fmt.Errorf("-thisweek and -nextweek can't be combined")
+	}
+	return nil
+}
+
+// isBooking reports whether the command line books a WFH day, which is what wfh does
+// when no other action is given.
+func (opts options) isBooking() bool {
+	return !(opts.list || opts.office || opts.appendNote != "" || opts.backfill || opts.revoke ||
+		opts.weekdays || opts.conflicts || opts.update || opts.calStatus || opts.isMonth || opts.sync || opts.recolor || opts.migrate != "" || opts.archive || opts.restoreFile != "" ||
+		opts.importFile != "" || opts.batchFile != "" || opts.taskPattern != nil || opts.previewLink || opts.colorLegend || opts.clearToday || opts.validateCred ||
+		opts.serve != "" || opts.plan || opts.undoLastN > 0 || opts.printConfig || opts.tokenInfo || opts.deleteID != "")
+}
+
+// resolve fills in the dates and the message, using the config for defaults.
+func (opts *options) resolve(config Config) error {
+	opts.calendarID = config.calendarID(opts.calendarArg)
+	var last lastBooking
+	if opts.repeatLast {
+		var err error
+		last, err = loadLastBooking(config.dir)
+		if err != nil {
+			return fmt.Errorf("-repeat-last: %w", err)
+		}
+		// what's given on the command line still wins.
+		if opts.calendarArg == "" {
+			opts.calendarID = last.CalendarID
+		}
+		if opts.color == 0 && last.ColorID != 0 {
+			opts.color = last.ColorID
+			opts.colorGiven = true
+		}
+	}
+	if config.ColorFromMessage && !opts.colorGiven {
+		opts.colorFromMessage = true
+	}
+	if opts.reminders == nil {
+		opts.reminders = config.Reminders
+	}
+	if opts.transparency == "" {
+		opts.transparency = config.Transparency
+	}
+	if opts.location == "" && !opts.office {
+		// location is where you work from home, not the office.
+		opts.location = config.EventLocation
+	}
+	opts.callbackPorts = config.CallbackPorts
+	if opts.authMode == "" {
+		opts.authMode = config.AuthMode
+	}
+	if opts.marker && opts.duration == 0 {
+		opts.duration = config.markerDuration
+		if opts.duration == 0 {
+			opts.duration = defaultMarkerDuration
+		}
+	}
+	if opts.timed && opts.duration == 0 {
+		opts.duration = config.defaultDuration
+		if opts.duration == 0 {
+			return fmt.Errorf("-start-time needs -duration, or default_duration in the config")
+		}
+	}
+	// Parse the date if provided
+	isWeek := false
+	if opts.dateArg != "" {
+		var err error
+		opts.date, isWeek, err = parseISOWeek(opts.dateArg, config.Location())
+		if err != nil {
+			return fmt.Errorf("invalid -date: %w", err)
+		}
+		if !isWeek {
+			opts.date, err = parseDate(opts.dateArg, config.now())
+			if err != nil {
+				// use today's date if the provided date is invalid
+				opts.date = config.now()
+			}
+		}
+	} else if opts.repeatLast {
+		opts.date, _ = parseRelativeDate("tomorrow", config.now())
+	} else {
+		// use today's date if no date is provided
+		opts.date = config.now()
+	}
+	opts.from, opts.to = opts.date, opts.date
+	if isWeek {
+		if !opts.isBooking() && !opts.list && !opts.previewLink {
+			return fmt.Errorf("a week can only be given to -date when booking or listing")
+		}
+		// the whole week, booking leaves out the days that aren't working days.
+		opts.to = opts.date.AddDate(0, 0, 6)
+	}
+	if len(opts.every) > 0 {
+		if isWeek {
+			return fmt.Errorf("-every starts on a day, not a week")
+		}
+		err := opts.resolveRecurrence(config)
+		if err != nil {
+			return err
+		}
+	}
+	// -sync defaults to the current month.
+	if opts.isMonth || (opts.sync && opts.fromArg == "") {
+		first := time.Date(opts.date.Year(), opts.date.Month(), 1, 0, 0, 0, 0, config.Location())
+		if opts.month != "" {
+			first, _ = time.ParseInLocation("2006-01", opts.month, config.Location())
+		}
+		opts.from, opts.to = first, first.AddDate(0, 1, -1)
+	}
+	if opts.fromArg != "" {
+		var err error
+		opts.from, err = parseDate(opts.fromArg, config.now())
+		if err != nil {
+			return fmt.Errorf("invalid -from date: %w", err)
+		}
+		opts.to = opts.from
+	}
+	if opts.toArg != "" {
+		var err error
+		opts.to, err = parseDate(opts.toArg, config.now())
+		if err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+	}
+	if opts.last > 0 {
+		// opts.date is today, -last can't be combined with -date.
+		opts.from, opts.to = opts.date.AddDate(0, 0, -opts.last), opts.date
+	}
+	if opts.thisWeek || opts.nextWeek {
+		// opts.date is today here too, weeks start on Monday.
+		monday := opts.date.AddDate(0, 0, -(int(opts.date.Weekday())+6)%7)
+		if opts.nextWeek {
+			monday = monday.AddDate(0, 0, 7)
+		}
+		opts.from, opts.to = monday, monday.AddDate(0, 0, 6)
+	}
+	if opts.to.Before(opts.from) {
+		return fmt.Errorf("-to is before -from")
+	}
+	if opts.isBooking() && !opts.force {
+		err := checkHorizon(config, opts.to)
+		if err != nil {
+			return err
+		}
+		err = checkNotice(config, opts.from)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.list || opts.weekdays || opts.conflicts || opts.update || opts.calStatus || opts.isMonth || opts.sync || opts.recolor || opts.migrate != "" || opts.archive || opts.restoreFile != "" ||
+		opts.colorLegend || opts.clearToday || opts.validateCred || opts.undoLastN > 0 || opts.tokenInfo || opts.deleteID != "" {
+		// only the message given on the command line is used to update an event.
+		opts.message = config.normalizeSummary(opts.messageArg)
+		return nil
+	}
+	switch {
+	case strings.TrimSpace(opts.messageArg) != "":
+		opts.message = opts.messageArg
+	case opts.repeatLast:
+		// it's stored with the emoji.
+		opts.message = last.Message
+		return nil
+	case opts.office && config.OfficeMessage != "":
+		opts.message = config.OfficeMessage
+	case opts.office:
+		opts.message = defaultOfficeMessage
+	default:
+		opts.message = config.DefaultMessage
+	}
+	opts.message = config.normalizeSummary(opts.message)
+	if !opts.office && !opts.noEmoji {
+		opts.message = withEmoji(config.SummaryEmoji, opts.message)
+	}
+	return nil
+}
+
+const examples = `
+Examples:
+  Book today as WFH, using default_message from the config:
+    wfh
+  Book a specific day with a custom message:
+    wfh -date 2024-06-04 -message "WFH (plumber)"
+  Book the working days of a week:
+    wfh -date 2024-W23
+  List a month:
+    wfh -list -from 2024-06-01 -to 2024-06-30
+  Review the last week:
+    wfh -list -last 7
+  Going to the office after all, replace the WFH booking:
+    wfh -office -date 2024-06-04
+  Delete today's WFH event, or any event by its ID from -list -verbose:
+    wfh -clear-today
+    wfh -delete-id 4k2j3h5g6f7d8s9a0
+`
+
+// usage prints the flags, some examples and where the config is read from. It must not
+// depend on authentication, so it only reads the config file.
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: wfh [flags]\n\nBooks \"Work From Home\" days in Google Calendar.\n\nFlags:\n")
+	flag.PrintDefaults()
+	_, _ = fmt.Fprint(out, examples)
+	_, _ = fmt.Fprintln(out, "\nConfiguration:")
+	configPath, err := getConfigPath()
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "  %v\n", err)
+		return
+	}
+	_, _ = fmt.Fprintf(out, "  config file: %s\n", filepath.Join(configPath, "config.json"))
+	config, err := getConfig(configPath)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "  unable to load: %v\n", err)
+		return
+	}
+	if config.baseFile != "" {
+		_, _ = fmt.Fprintf(out, "  base config: %s\n", config.baseFile)
+	}
+	// -calendar and -primary as far as they're parsed, defaults included. Calendar names
+	// from the calendar list are only looked up when wfh runs.
+	name := ""
+	if f := flag.Lookup("calendar"); f != nil {
+		name = strings.TrimSpace(f.Value.String())
+	}
+	if f := flag.Lookup("primary"); f != nil && f.Value.String() == "true" {
+		name = primaryCalendar
+	}
+	_, _ = fmt.Fprintf(out, "  calendar:    %s\n", config.calendarID(name))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// stopClock makes the clock return t for the rest of the test.
+func stopClock(t *testing.T, now time.Time) {
+	saved := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = saved })
+}
+
+func TestRelativeDates(t *testing.T) {
+	// late on a Wednesday, when UTC is already on Thursday.
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	stopClock(t, time.Date(2024, 6, 5, 23, 30, 0, 0, oslo).UTC())
+	config := Config{location: oslo}
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"today", "2024-06-05"},
+		{"Tomorrow", "2024-06-06"},
+		{"yesterday", "2024-06-04"},
+		{"+7", "2024-06-12"},
+		{"-3", "2024-06-02"},
+		{"2024-12-24", "2024-12-24"},
+	}
+	for _, tt := range tests {
+		day, err := parseDate(tt.arg, config.now())
+		if err != nil {
+			t.Errorf("parseDate(%q): %v", tt.arg, err)
+			continue
+		}
+		if got := day.Format("2006-01-02"); got != tt.want {
+			t.Errorf("parseDate(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+		if day.Location() != oslo {
+			t.Errorf("parseDate(%q) is in %s, want Europe/Oslo", tt.arg, day.Location())
+		}
+	}
+	for _, arg := range []string{"", "soon", "+", "+x", "2024-13-01"} {
+		if _, err := parseDate(arg, config.now()); err == nil {
+			t.Errorf("parseDate(%q) succeeded", arg)
+		}
+	}
+	if _, ok := parseRelativeDate("2024-06-05", config.now()); ok {
+		t.Errorf("parseRelativeDate took a date")
+	}
+}
+
+func TestWeekWindows(t *testing.T) {
+	tests := []struct {
+		name     string
+		today    time.Time
+		opts     options
+		from, to string
+	}{
+		{"this week on a Wednesday", time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), options{list: true, thisWeek: true}, "2024-06-03", "2024-06-09"},
+		{"next week on a Wednesday", time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), options{list: true, nextWeek: true}, "2024-06-10", "2024-06-16"},
+		{"this week on a Sunday", time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC), options{list: true, thisWeek: true}, "2024-06-03", "2024-06-09"},
+		{"next week on a Monday", time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC), options{list: true, nextWeek: true}, "2024-06-10", "2024-06-16"},
+		{"this week across months", time.Date(2024, 7, 31, 12, 0, 0, 0, time.UTC), options{list: true, thisWeek: true}, "2024-07-29", "2024-08-04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopClock(t, tt.today)
+			opts := tt.opts
+			err := opts.resolve(Config{location: time.UTC})
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			from, to := opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02")
+			if from != tt.from || to != tt.to {
+				t.Errorf("got %s to %s, want %s to %s", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []string
+		want string // the error, empty if none
+	}{
+		{"booking", nil, ""},
+		{"booking with modifiers", []string{"date", "message", "color", "dry-run"}, ""},
+		{"two actions", []string{"list", "office"}, "-list and -office can't be combined"},
+		{"action modifier", []string{"list", "from", "to", "verbose"}, ""},
+		{"booking with a listing modifier", []string{"verbose"}, "-verbose can't be used when booking"},
+		{"action with another's modifier", []string{"office", "verbose"}, "-verbose can't be used with -office"},
+		{"date and from", []string{"list", "date", "from"}, "-date can't be combined with -from/-to"},
+		{"last and to", []string{"list", "last", "to"}, "-last can't be combined with -date or -from/-to"},
+		{"thisweek and last", []string{"list", "thisweek", "last"}, "-thisweek can't be combined with -date, -from/-to or -last"},
+		{"thisweek and nextweek", []string{"list", "thisweek", "nextweek"}, "-thisweek and -nextweek can't be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := make(map[string]bool)
+			for _, name := range tt.set {
+				set[name] = true
+			}
+			err := checkConflicts(set)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("checkConflicts(%v) = %q, want %q", tt.set, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckConflictsPairs checks every pair of actions, and every modifier with every
+// action, booking included, against actionFlags and modifierFlags.
+func TestCheckConflictsPairs(t *testing.T) {
+	for i, a := range actionFlags {
+		for _, b := range actionFlags[i+1:] {
+			err := checkConflicts(map[string]bool{a: true, b: true})
+			want := "-" + a + " and -" + b + " can't be combined"
+			if err == nil || err.Error() != want {
+				t.Errorf("-%s -%s: got %v, want %q", a, b, err, want)
+			}
+		}
+	}
+	actions := append([]string{""}, actionFlags...)
+	for modifier, allowed := range modifierFlags {
+		for _, action := range allowed {
+			if !slices.Contains(actions, action) {
+				t.Errorf("-%s is allowed with -%s, which isn't an action", modifier, action)
+			}
+		}
+		for _, action := range actions {
+			set := map[string]bool{modifier: true}
+			want := "-" + modifier + " can't be used when booking"
+			if action != "" {
+				set[action] = true
+				want = "-" + modifier + " can't be used with -" + action
+			}
+			err := checkConflicts(set)
+			if slices.Contains(allowed, action) {
+				if err != nil {
+					t.Errorf("-%s with %q: %v", modifier, action, err)
+				}
+				continue
+			}
+			if err == nil || err.Error() != want {
+				t.Errorf("-%s with %q: got %v, want %q", modifier, action, err, want)
+			}
+		}
+	}
+}
+
+func TestCheckColor(t *testing.T) {
+	tests := []struct {
+		colorID int
+		ok      bool
+	}{
+		{-1, false},
+		{0, true}, // a random color
+		{1, true},
+		{11, true},
+		{12, false},
+	}
+	for _, tt := range tests {
+		err := checkColor(tt.colorID)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkColor(%d) = %v, want ok %t", tt.colorID, err, tt.ok)
+		}
+	}
+}
+
+func TestRelativeDatesAcrossDST(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	config := Config{location: oslo}
+	// the clocks in Oslo went from 02:00 to 03:00 on 2024-03-31, so that day had 23
+	// hours, and back from 03:00 to 02:00 on 2024-10-27, which had 25.
+	tests := []struct {
+		now  time.Time
+		arg  string
+		want string
+	}{
+		{time.Date(2024, 3, 30, 23, 30, 0, 0, oslo), "tomorrow", "2024-03-31"},
+		{time.Date(2024, 3, 30, 23, 30, 0, 0, oslo), "+1", "2024-03-31"},
+		{time.Date(2024, 3, 30, 23, 30, 0, 0, oslo), "+2", "2024-04-01"},
+		{time.Date(2024, 3, 30, 12, 0, 0, 0, oslo), "+7", "2024-04-06"},
+		{time.Date(2024, 4, 1, 0, 30, 0, 0, oslo), "yesterday", "2024-03-31"},
+		{time.Date(2024, 4, 1, 0, 30, 0, 0, oslo), "-2", "2024-03-30"},
+		{time.Date(2024, 10, 26, 23, 30, 0, 0, oslo), "tomorrow", "2024-10-27"},
+		{time.Date(2024, 10, 26, 23, 30, 0, 0, oslo), "+2", "2024-10-28"},
+	}
+	for _, tt := range tests {
+		stopClock(t, tt.now)
+		day, err := parseDate(tt.arg, config.now())
+		if err != nil {
+			t.Errorf("parseDate(%q): %v", tt.arg, err)
+			continue
+		}
+		if got := day.Format("2006-01-02"); got != tt.want {
+			t.Errorf("%q at %s = %s, want %s", tt.arg, tt.now.Format(time.RFC3339), got, tt.want)
+		}
+		if day.Hour() != 0 || day.Minute() != 0 {
+			t.Errorf("%q at %s starts at %s, want midnight", tt.arg, tt.now.Format(time.RFC3339), day.Format("15:04"))
+		}
+	}
+}
+
+func TestCheckNotice(t *testing.T) {
+	stopClock(t, time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		minNotice int
+		day       string
+		ok        bool
+	}{
+		{0, "2024-06-02", false}, // past days need -force, whatever min_notice_days says
+		{0, "2024-06-03", true},
+		{2, "2024-05-01", false},
+		{2, "2024-06-03", false},
+		{2, "2024-06-04", false},
+		{2, "2024-06-05", true},
+	}
+	for _, tt := range tests {
+		day, _ := time.ParseInLocation("2006-01-02", tt.day, time.UTC)
+		err := checkNotice(Config{location: time.UTC, MinNoticeDays: tt.minNotice}, day)
+		if (err == nil) != tt.ok {
+			t.Errorf("min_notice_days %d, %s: got %v, want ok %t", tt.minNotice, tt.day, err, tt.ok)
+		}
+	}
+}
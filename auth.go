@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"html/template"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//go:embed credentials.json
+var googleCredentials []byte
+
+// If modifying these scopes, delete your previously saved token.json.
+var scopes = []string{calendar.CalendarEventsScope, calendar.CalendarReadonlyScope}
+
+// microsoftScopes are the Graph permissions wfh needs. offline_access gets us a refresh token.
+var microsoftScopes = []string{"Calendars.ReadWrite", "offline_access"}
+
+// microsoftConfig returns the OAuth config for logging in with Microsoft. The app
+// registration must be a public client with http://localhost as a redirect URI.
+func microsoftConfig(config Config) *oauth2.Config {
+	tenant := config.MicrosoftTenant
+	if tenant == "" {
+		tenant = "common"
+	}
+	return &oauth2.Config{
+		ClientID: config.MicrosoftClientID,
+		Endpoint: microsoft.AzureADEndpoint(tenant),
+		Scopes:   microsoftScopes,
+	}
+}
+
+// checkCredentials looks for problems with OAuth client credentials that would only
+// show up halfway through the login.
+func checkCredentials(b []byte) []string {
+	_, err := google.ConfigFromJSON(b, scopes...)
+	if err != nil {
+		return []string{fmt.Sprintf("google.ConfigFromJSON: %v", err)}
+	}
+	// ConfigFromJSON only keeps the first redirect URI, the others count too.
+	var file map[string]struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return []string{fmt.Sprintf("json.Unmarshal: %v", err)}
+	}
+	var problems []string
+	for kind, c := range file {
+		if kind != "installed" {
+			problems = append(problems, fmt.Sprintf("the client is of type %q, wfh needs a desktop app (\"installed\") client", kind))
+		}
+		if !strings.HasSuffix(c.ClientID, ".apps.googleusercontent.com") {
+			problems = append(problems, fmt.Sprintf("client_id %q doesn't look like a Google OAuth client ID", c.ClientID))
+		}
+		if c.ClientSecret == "" {
+			problems = append(problems, "client_secret is empty")
+		}
+		if !slices.ContainsFunc(c.RedirectURIs, isLocalRedirect) {
+			problems = append(problems, fmt.Sprintf("none of the redirect URIs %q is %s or http://localhost", c.RedirectURIs, redirectURL))
+		}
+	}
+	return problems
+}
+
+// isLocalRedirect reports whether uri lets the login redirect to our local server.
+// Desktop clients may redirect to any port on a registered loopback address.
+func isLocalRedirect(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	return (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1") && (u.Port() == "" || u.Port() == "8066")
+}
+
+// serviceAccountFile returns the service account key to authenticate with, if any.
+// service_account_file in the config wins over GOOGLE_APPLICATION_CREDENTIALS, which
+// is only used if it's a service account key. gcloud also puts user logins there.
+func serviceAccountFile(config Config) string {
+	if config.ServiceAccountFile != "" {
+		return config.ServiceAccountFile
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" || !isServiceAccountKey(path) {
+		return ""
+	}
+	return path
+}
+
+// isServiceAccountKey reports whether the file is a service account key, rather than
+// e.g. an authorized_user file from gcloud auth application-default login.
+func isServiceAccountKey(path string) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var key struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(b, &key) == nil && key.Type == "service_account"
+}
+
+// getServiceAccountClient authenticates with a service account key, impersonating the
+// user. This needs domain-wide delegation, but no browser.
+func getServiceAccountClient(keyFile, user string) (*calendar.Service, error) {
+	if !strings.Contains(user, "@") {
+		return nil, fmt.Errorf("\"user\" in the config must be the email address to impersonate, not %q", user)
+	}
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(%s): %w", keyFile, err)
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("google.JWTConfigFromJSON(%s): %w", keyFile, err)
+	}
+	jwtConfig.Subject = user
+	ctx := context.Background()
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(jwtConfig.Client(ctx)))
+	if err != nil {
+		return nil, fmt.Errorf("calendar.NewService: %w", err)
+	}
+	return srv, nil
+}
+
+func getClient(config *oauth2.Config, tokenPath string, mode string, ports []int, refresh bool) *calendar.Service {
+	srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(getHTTPClient(config, tokenPath, mode, ports, refresh)))
+	if err != nil {
+		fatalf("Unable to retrieve Calendar client: %v", err)
+	}
+	return srv
+}
+
+// exitLoginRequired is the exit status when wfh has to log in but there's no terminal
+// to do it in, so scripts can tell it apart from other failures.
+const exitLoginRequired = 3
+
+// loginRequired stops wfh when it has to log in without a terminal, from cron or a
+// service. Waiting for a login no one sees would hang it for good.
+func loginRequired(tokenPath string) {
+	_, _ = fmt.Fprintf(os.Stderr, "Re-authentication required: %s is missing or expired and can't be refreshed.\n"+
+		"Run wfh in a terminal to log in, on this machine or another, and copy the token to %s.\n", tokenPath, tokenPath)
+	exit(exitLoginRequired)
+}
+
+// getHTTPClient returns an HTTP client authorized with the saved token, logging in
+// through the browser first if there is none. Without a terminal, it exits with
+// exitLoginRequired instead. With refresh, a new access token is fetched right away,
+// rather than when the current one expires halfway through a batch.
+func getHTTPClient(config *oauth2.Config, tokenPath string, mode string, ports []int, refresh bool) *http.Client {
+	tok, err := tokenFromFile(tokenPath)
+	if err != nil {
+		if !isTerminal(os.Stdin) {
+			loginRequired(tokenPath)
+		}
+		tok, err = getTokenFromWeb(config, tokenPath, mode, ports)
+		if err != nil {
+			fatalf("Unable to log in: %v", err)
+		}
+	}
+	if tok != nil {
+		if len(tok.RefreshToken) == 0 {
+			if !tok.Valid() && !isTerminal(os.Stdin) {
+				loginRequired(tokenPath)
+			}
+			log.Printf("No refresh token found, please delete %s, revoke the token and try again.", filepath.Base(tokenPath))
+		}
+	}
+	ctx := context.Background()
+	current := *tok
+	if refresh {
+		// an expired token is refreshed the first time it's used.
+		tok.Expiry = time.Now().Add(-time.Minute)
+	}
+	src := &savingTokenSource{src: config.TokenSource(ctx, tok), path: tokenPath, saved: current.AccessToken}
+	if refresh {
+		_, err := src.Token()
+		if err != nil {
+			fatalf("Unable to refresh the token: %v", err)
+		}
+		fmt.Println("Token refreshed.")
+	}
+	return oauth2.NewClient(ctx, src)
+}
+
+// savingTokenSource saves the token when it's refreshed. Otherwise every run starts with
+// the expired token from the last login and refreshes it again, and a new refresh token,
+// which some providers hand out with every refresh, is lost.
+type savingTokenSource struct {
+	src  oauth2.TokenSource
+	path string
+
+	mu    sync.Mutex
+	saved string // the access token in the file
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.saved {
+		err := saveToken(s.path, tok)
+		if err != nil {
+			// the token works, it just has to be refreshed again next time.
+			log.Printf("Unable to save the refreshed token: %v", err)
+		}
+		s.saved = tok.AccessToken
+	}
+	return tok, nil
+}
+
+// callbackResult is what the OAuth redirect brought back.
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// Error is used by callbackPage.
+func (r callbackResult) Error() string {
+	if r.err == nil {
+		return ""
+	}
+	return r.err.Error()
+}
+
+// callbackPage is shown in the browser after the OAuth redirect. window.close() only works
+// in some browsers, hence the fallback text.
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>wfh</title>
+<style>
+body { font-family: sans-serif; background: #f1f3f4; display: flex; justify-content: center; margin-top: 15vh; }
+.box { background: #fff; border-radius: 8px; box-shadow: 0 1px 4px rgba(0, 0, 0, .2); padding: 2em 3em; text-align: center; }
+.ok { color: #188038; }
+.error { color: #d93025; }
+</style>
+</head>
+<body>
+<div class="box">
+{{if .Error}}
+<h1 class="error">Authorization failed</h1>
+<p>{{.Error}}</p>
+<p>Go back to the terminal and try again.</p>
+{{else}}
+<h1 class="ok">wfh is authorized</h1>
+<p>You may close this tab.</p>
+<script>window.close();</script>
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+// redirectURL is where the provider sends the browser after consent. It must be
+// registered with the OAuth client.
+const redirectURL = "http://localhost:8066/"
+
+// defaultCallbackPort is the port of redirectURL, used when callback_ports isn't set.
+const defaultCallbackPort = 8066
+
+// Ways to get the authorization code from the browser to wfh.
+const (
+	// authLocal receives the code on a local server at redirectURL.
+	authLocal = "local"
+	// authPaste has the user paste the address the browser failed to load at redirectURL.
+	authPaste = "paste"
+	// authOOB uses the provider's out-of-band redirect, which shows the code instead of
+	// redirecting to localhost, for networks where localhost redirects are blocked.
+	authOOB = "oob"
+)
+
+// microsoftOOBRedirectURL is Microsoft's native client page, the out-of-band redirect
+// for public clients that register it. Google blocked its out-of-band redirect for all
+// OAuth clients in January 2023, so there's none for Google.
+const microsoftOOBRedirectURL = "https://login.microsoftonline.com/common/oauth2/nativeclient"
+
+// supportsOOB reports whether the config's provider still has an out-of-band redirect.
+func supportsOOB(config *oauth2.Config) bool {
+	return strings.Contains(config.Endpoint.AuthURL, "login.microsoftonline.com")
+}
+
+// Request a token from the web, then returns the retrieved token. mode is one of
+// authLocal, authPaste and authOOB. The local server listens on the first of ports
+// that is free.
+func getTokenFromWeb(config *oauth2.Config, tokenPath string, mode string, ports []int) (*oauth2.Token, error) {
+	// make a state token to prevent CSRF attacks:
+	state := randomString(16)
+	redirect := redirectURL
+	if mode == authOOB && !supportsOOB(config) {
+		fmt.Println("Google no longer supports the out-of-band login, pasting the code like -paste-code instead.")
+		mode = authPaste
+	}
+	var ln net.Listener
+	switch mode {
+	case authOOB:
+		redirect = microsoftOOBRedirectURL
+	case authPaste:
+	default:
+		// listen before handing out the link, so it has the port that is actually used.
+		var port int
+		var err error
+		ln, port, err = callbackListener(ports)
+		if err != nil {
+			return nil, err
+		}
+		redirect = fmt.Sprintf("http://localhost:%d/", port)
+	}
+	authURL := config.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("redirect_uri", redirect),
+	)
+	var result callbackResult
+	switch mode {
+	case authPaste:
+		result = codeFromPaste(authURL, state,
+			"After consenting, the browser fails to load a localhost page. That's expected.\n"+
+				"Paste the address of that page, or the code in it: ")
+	case authOOB:
+		result = codeFromPaste(authURL, state,
+			"After consenting, you get a code, or a blank page with the code in its address.\n"+
+				"Paste the code, or the address: ")
+	default:
+		result = codeFromCallback(authURL, state, ln)
+	}
+	if result.err != nil {
+		return nil, fmt.Errorf("authorization failed: %w", result.err)
+	}
+	return exchangeCode(config, result.code, redirect, tokenPath)
+}
+
+// exchangeCode trades the authorization code for a token at the config's token
+// endpoint, and saves the token.
+func exchangeCode(config *oauth2.Config, code, redirect, tokenPath string) (*oauth2.Token, error) {
+	tok, err := config.Exchange(context.TODO(), code,
+		oauth2.SetAuthURLParam("redirect_uri", redirect))
+	if err != nil {
+		return nil, fmt.Errorf("config.Exchange: %w", err)
+	}
+	err = saveToken(tokenPath, tok)
+	if err != nil {
+		return nil, fmt.Errorf("saveToken: %w", err)
+	}
+	return tok, nil
+}
+
+// callbackListener listens on the first of ports that isn't in use, and returns the
+// port. Only ports registered with the OAuth client will do, so it doesn't look further.
+func callbackListener(ports []int) (net.Listener, int, error) {
+	if len(ports) == 0 {
+		ports = []int{defaultCallbackPort}
+	}
+	for i, port := range ports {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if errors.Is(err, syscall.EADDRINUSE) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("net.Listen: %w", err)
+		}
+		if i > 0 {
+			fmt.Printf("Port %d is in use, waiting for the login on port %d instead.\n", ports[0], port)
+		}
+		return ln, port, nil
+	}
+	return nil, 0, fmt.Errorf("the login ports %v are all in use, free one or add another to callback_ports", ports)
+}
+
+// codeFromCallback prints the auth URL and waits for the browser to be redirected to a
+// local server listening on ln, with the code.
+func codeFromCallback(authURL, state string, ln net.Listener) callbackResult {
+	// We'll use a channel to block until we get the authorization code
+	resultCh := make(chan callbackResult)
+
+	// Start a local server to listen on a specified port. It has a mux of its own, the
+	// default one only takes the handler once.
+	mux := http.NewServeMux()
+	srv := &http.Server{Handler: mux}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		recvState := r.URL.Query().Get("state")
+		if recvState != state {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = callbackPage.Execute(w, callbackResult{err: fmt.Errorf("invalid state: %q", recvState)}) // nolint: errcheck
+			return
+		}
+		result := callbackFromQuery(r.URL.Query(), state)
+		_ = callbackPage.Execute(w, result) // nolint: errcheck
+		resultCh <- result                  // Send the result to our waiting getTokenFromWeb function
+	})
+
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			log.Fatalf("Serve(): %v", err)
+		}
+	}()
+
+	// The redirect URL, `http://localhost:8066/` unless callback_ports says otherwise,
+	// must match one of the URIs set in the Google Developer Console.
+	fmt.Printf("Go to the following link in your browser:\n%v\n", authURL)
+
+	// Block until we receive the code
+	result := <-resultCh
+	// Shutdown the server
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel() // Cancel context when done to release resources
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server Shutdown: %v", err)
+	}
+	return result
+}
+
+// codeFromPaste prints the auth URL and reads the code from stdin. Either the address
+// the browser ended up at or just the code can be pasted. prompt tells the user which
+// page to expect.
+func codeFromPaste(authURL, state, prompt string) callbackResult {
+	fmt.Printf("Open the following link in a browser on any device:\n%v\n\n", authURL)
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return callbackResult{err: fmt.Errorf("reading the code: %w", err)}
+	}
+	line = strings.TrimSpace(line)
+	if !strings.Contains(line, "code=") && !strings.Contains(line, "error=") {
+		return callbackResult{code: line}
+	}
+	u, err := url.Parse(line)
+	if err != nil {
+		return callbackResult{err: fmt.Errorf("url.Parse: %w", err)}
+	}
+	return callbackFromQuery(u.Query(), state)
+}
+
+// callbackFromQuery gets the code from the query of the redirect.
+func callbackFromQuery(query url.Values, state string) callbackResult {
+	if recvState := query.Get("state"); recvState != state {
+		return callbackResult{err: fmt.Errorf("invalid state: %q", recvState)}
+	}
+	result := callbackResult{code: query.Get("code")}
+	// the provider redirects with an error, e.g. access_denied, if consent isn't given.
+	if e := query.Get("error"); e != "" {
+		result.err = fmt.Errorf("login returned %q", e)
+	} else if result.code == "" {
+		result.err = fmt.Errorf("no authorization code received")
+	}
+	return result
+}
+
+// randomString returns a random string of the specified length, using A-Z, a-z, 0-9
+func randomString(i int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, i)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// Retrieves a token from a local file.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	if err != nil {
+		fatalf("Unable to decode token: %v", err)
+	}
+	return tok, err
+}
+
+// Saves a token to a file path.
+func saveToken(path string, token *oauth2.Token) error {
+	// the token grants access to the calendar, keep it private.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %w", err)
+	}
+	// OpenFile only applies the mode to new files, tighten up tokens saved by older versions.
+	err = f.Chmod(0600)
+	if err != nil {
+		return fmt.Errorf("f.Chmod: %w", err)
+	}
+
+	err = json.NewEncoder(f).Encode(token)
+	if err != nil {
+		return fmt.Errorf("json.NewEncoder.Encode: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("f.Close: %w", err)
+	}
+	return nil
+}
+
+// tokenFile returns the token file of the configured provider.
+func tokenFile(config Config, configPath string) string {
+	if config.Provider == providerMicrosoft {
+		return filepath.Join(configPath, "ms-token.json")
+	}
+	return filepath.Join(configPath, "token.json")
+}
+
+// printTokenInfo tells whether the stored token is still good, and whether it can be
+// refreshed, without showing the token itself.
+func printTokenInfo(w io.Writer, tokenPath string) error {
+	tok, err := tokenFromFile(tokenPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no token in %s, run wfh to log in", tokenPath)
+	}
+	if err != nil {
+		return fmt.Errorf("tokenFromFile: %w", err)
+	}
+	expiresAt := "never"
+	if !tok.Expiry.IsZero() {
+		expiresAt = tok.Expiry.Local().Format(time.RFC3339)
+	}
+	_, _ = fmt.Fprintf(w, "token_file: %s\n", tokenPath)
+	_, _ = fmt.Fprintf(w, "valid: %t\n", tok.Valid())
+	_, _ = fmt.Fprintf(w, "expires_at: %s\n", expiresAt)
+	_, _ = fmt.Fprintf(w, "has_refresh_token: %t\n", tok.RefreshToken != "")
+	if !tok.Valid() && tok.RefreshToken == "" {
+		_, _ = fmt.Fprintln(w, "The token can't be refreshed, run wfh to log in again.")
+	}
+	return nil
+}
+
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// revokeToken revokes the stored token with Google and deletes the token file.
+func revokeToken(tokenPath string) error {
+	tok, err := tokenFromFile(tokenPath)
+	if err != nil {
+		return fmt.Errorf("tokenFromFile: %w", err)
+	}
+	// revoking the refresh token also revokes the access tokens issued from it.
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	resp, err := http.PostForm(revokeURL, url.Values{"token": {token}})
+	if err != nil {
+		return fmt.Errorf("http.PostForm: %w", err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("revocation failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	err = os.Remove(tokenPath)
+	if err != nil {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"golang.org/x/oauth2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeTokenServer answers the code exchange with a token for the code "good".
+func fakeTokenServer(t *testing.T, redirect string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			t.Errorf("r.ParseForm: %v", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "authorization_code" {
+			t.Errorf("grant_type is %q, want authorization_code", got)
+		}
+		if got := r.PostForm.Get("redirect_uri"); got != redirect {
+			t.Errorf("redirect_uri is %q, want %q", got, redirect)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExchangeCode(t *testing.T) {
+	srv := fakeTokenServer(t, redirectURL)
+	config := &oauth2.Config{ClientID: "id", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+	tokenPath := filepath.Join(t.TempDir(), "token.json")
+
+	tok, err := exchangeCode(config, "good", redirectURL, tokenPath)
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+	saved, err := tokenFromFile(tokenPath)
+	if err != nil {
+		t.Fatalf("tokenFromFile: %v", err)
+	}
+	if saved.AccessToken != "access" || saved.RefreshToken != "refresh" {
+		t.Errorf("saved token %+v", saved)
+	}
+	fi, err := os.Stat(tokenPath)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("the token file has mode %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestExchangeCodeRejected(t *testing.T) {
+	srv := fakeTokenServer(t, redirectURL)
+	config := &oauth2.Config{ClientID: "id", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+	tokenPath := filepath.Join(t.TempDir(), "token.json")
+
+	_, err := exchangeCode(config, "bad", redirectURL, tokenPath)
+	if err == nil {
+		t.Fatal("exchangeCode succeeded with a rejected code")
+	}
+	if _, err := os.Stat(tokenPath); !os.IsNotExist(err) {
+		t.Errorf("a token was saved for a rejected code: %v", err)
+	}
+}
+
+func TestCodeFromCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode string
+		wantErr  bool
+	}{
+		{"code", "?state=s&code=good", "good", false},
+		{"consent refused", "?state=s&error=access_denied", "", true},
+		{"no code", "?state=s", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.Listen: %v", err)
+			}
+			results := make(chan callbackResult)
+			go func() {
+				results <- codeFromCallback("https://example.com/auth", "s", ln)
+			}()
+			base := "http://" + ln.Addr().String() + "/"
+			// a request with the wrong state is refused, and the wait goes on.
+			resp, err := http.Get(base + "?state=wrong&code=evil")
+			if err != nil {
+				t.Fatalf("http.Get: %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("wrong state got %s, want 400", resp.Status)
+			}
+			resp, err = http.Get(base + tt.query)
+			if err != nil {
+				t.Fatalf("http.Get: %v", err)
+			}
+			_ = resp.Body.Close()
+			result := <-results
+			if result.code != tt.wantCode || (result.err != nil) != tt.wantErr {
+				t.Errorf("got code %q, error %v", result.code, result.err)
+			}
+		})
+	}
+}
+
+func TestServiceAccountFile(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.json")
+	user := filepath.Join(dir, "application_default_credentials.json")
+	err := os.WriteFile(key, []byte(`{"type": "service_account", "client_email": "wfh@example.iam.gserviceaccount.com"}`), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	err = os.WriteFile(user, []byte(`{"type": "authorized_user", "refresh_token": "r"}`), 0600)
+	if err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	tests := []struct {
+		name   string
+		config string
+		env    string
+		want   string
+	}{
+		{"none", "", "", ""},
+		{"service account in the environment", "", key, key},
+		{"user login in the environment", "", user, ""},
+		{"missing file in the environment", "", filepath.Join(dir, "missing.json"), ""},
+		{"config wins", "/etc/wfh/key.json", key, "/etc/wfh/key.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tt.env)
+			got := serviceAccountFile(Config{ServiceAccountFile: tt.config})
+			if got != tt.want {
+				t.Errorf("serviceAccountFile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backupEntry is a deleted event, and the calendar it was in.
+type backupEntry struct {
+	CalendarID string          `json:"calendar_id"`
+	Event      *calendar.Event `json:"event"`
+}
+
+// backup keeps the events a command deletes in a file of its own in ~/.wfh/backups,
+// so -restore can bring them back.
+type backup struct {
+	dir     string
+	created time.Time
+	// path is the file, once events have been added.
+	path    string
+	entries []backupEntry
+}
+
+// newBackup returns a backup named after the current time. Nothing is written until
+// events are added.
+func newBackup(config Config) *backup {
+	return &backup{dir: filepath.Join(config.dir, "backups"), created: config.now()}
+}
+
+// add saves the events to the backup file, before they're deleted. The file is written
+// again in full every time, so it has everything deleted so far if wfh stops halfway.
+func (b *backup) add(calendarID string, items ...*calendar.Event) error {
+	if len(items) == 0 {
+		return nil
+	}
+	first := len(b.entries) == 0
+	for _, item := range items {
+		b.entries = append(b.entries, backupEntry{CalendarID: calendarID, Event: item})
+	}
+	data, err := json.MarshalIndent(b.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	if b.path == "" {
+		err = os.MkdirAll(b.dir, 0700)
+		if err != nil {
+			return fmt.Errorf("os.MkdirAll: %w", err)
+		}
+		// named after the time, with a random part so backups in the same second don't
+		// overwrite each other.
+		f, err := os.CreateTemp(b.dir, b.created.Format("20060102-150405")+"-*.json")
+		if err != nil {
+			return fmt.Errorf("os.CreateTemp: %w", err)
+		}
+		_ = f.Close()
+		b.path = f.Name()
+	}
+	err = os.WriteFile(b.path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	if first {
+		fmt.Printf("Deleted events are backed up to %s, use -restore to bring them back.\n", b.path)
+	}
+	return nil
+}
+
+// restoreBackup creates the events in a backup again, in the calendars they were
+// deleted from. Events that are there already, or were restored before, are skipped, so
+// a backup can be restored again after a failure.
+func restoreBackup(backend wfh.Backend, config Config, opts options) error {
+	data, err := os.ReadFile(opts.restoreFile)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var entries []backupEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	done, failed := 0, 0
+	for _, entry := range entries {
+		item := entry.Event
+		if item == nil {
+			continue
+		}
+		date := wfh.EventDate(item)
+		client := wfh.NewBackendClient(backend, entry.CalendarID, config.clientOptions(config.calendarLocation(entry.CalendarID))...)
+		there, err := restored(client, item)
+		if err != nil {
+			fmt.Printf("Unable to check %s %q: %v\n", date, item.Summary, err)
+			failed++
+			continue
+		}
+		if there {
+			fmt.Printf("Skipping %s %q, it's there already\n", date, item.Summary)
+			continue
+		}
+		if opts.dryRun {
+			fmt.Printf("Dry run, would restore %s %q in %s\n", date, item.Summary, calendarName(config, entry.CalendarID))
+			done++
+			continue
+		}
+		err = client.Restore([]*calendar.Event{item})
+		if err != nil {
+			fmt.Printf("Unable to restore %s %q: %v\n", date, item.Summary, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Restored %s %q in %s\n", date, item.Summary, calendarName(config, entry.CalendarID))
+		done++
+	}
+	fmt.Printf("Restored %d of %d event(s)\n", done, len(entries))
+	if failed > 0 {
+		return fmt.Errorf("%d event(s) weren't restored", failed)
+	}
+	return nil
+}
+
+// restored reports whether the calendar has the event: the same summary starting at the
+// same time. A restored event has a new ID, so the ID won't do.
+func restored(client *wfh.Client, item *calendar.Event) (bool, error) {
+	day, err := time.Parse("2006-01-02", wfh.EventDate(item))
+	if err != nil {
+		return false, fmt.Errorf("the event has no start")
+	}
+	items, err := client.List(wfh.Day(day), wfh.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("client.List: %w", err)
+	}
+	for _, existing := range items {
+		if existing.Summary == item.Summary && existing.Start != nil && item.Start != nil &&
+			existing.Start.Date == item.Start.Date && existing.Start.DateTime == item.Start.DateTime {
+			return true, nil
+		}
+	}
+	return false, nil
+}
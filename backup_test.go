@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	calendar "google.golang.org/api/calendar/v3"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackupsInTheSameSecond(t *testing.T) {
+	stopClock(t, time.Date(2024, 6, 3, 9, 15, 0, 0, time.UTC))
+	config := Config{dir: t.TempDir(), location: time.UTC}
+	first, second := newBackup(config), newBackup(config)
+	err := first.add("primary", &calendar.Event{Id: "a", Summary: "WFH"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	err = second.add("primary", &calendar.Event{Id: "b", Summary: "WFH"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if first.path == second.path {
+		t.Fatalf("both backups are in %s", first.path)
+	}
+	// adding more rewrites the same file.
+	path := first.path
+	err = first.add("primary", &calendar.Event{Id: "c", Summary: "WFH"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if first.path != path {
+		t.Errorf("the backup moved from %s to %s", path, first.path)
+	}
+	for _, b := range []*backup{first, second} {
+		data, err := os.ReadFile(b.path)
+		if err != nil {
+			t.Fatalf("os.ReadFile: %v", err)
+		}
+		var entries []backupEntry
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		if len(entries) != len(b.entries) {
+			t.Errorf("%s has %d events, want %d", b.path, len(entries), len(b.entries))
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"os"
+	"time"
+)
+
+// batchOp is one operation in a -batch file.
+type batchOp struct {
+	// Op is "book" or "delete".
+	Op string `json:"op"`
+	// Date is YYYY-MM-DD or relative, like tomorrow or +2.
+	Date string `json:"date"`
+	// ID deletes exactly that event, instead of the WFH events on Date.
+	ID          string `json:"id"`
+	Message     string `json:"message"`
+	ColorID     int    `json:"color"`
+	Description string `json:"description"`
+
+	day time.Time
+}
+
+// loadBatch reads and checks a -batch file, so a mistake in it is found before anything
+// is booked or deleted.
+func loadBatch(path string, config Config, opts options) ([]batchOp, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var ops []batchOp
+	err = json.Unmarshal(b, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	today := config.now()
+	for i := range ops {
+		op := &ops[i]
+		if op.Op != "book" && op.Op != "delete" {
+			return nil, fmt.Errorf("operation %d: op must be book or delete, not %q", i+1, op.Op)
+		}
+		if op.ID != "" {
+			if op.Op != "delete" || op.Date != "" {
+				return nil, fmt.Errorf("operation %d: id is only for delete, instead of date", i+1)
+			}
+			continue
+		}
+		if op.Date == "" {
+			return nil, fmt.Errorf("operation %d: date is required", i+1)
+		}
+		op.day, err = parseDate(op.Date, today)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		if op.Op == "delete" {
+			continue
+		}
+		err = checkColor(op.ColorID)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		if !opts.force {
+			err = checkHorizon(config, op.day)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i+1, err)
+			}
+			err = checkNotice(config, op.day)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i+1, err)
+			}
+		}
+	}
+	return ops, nil
+}
+
+// runBatch runs the operations in a -batch file in order, reporting the result of each.
+// Like booking several days, a failed operation doesn't stop the rest, unless -fail-fast.
+func runBatch(client *wfh.Client, config Config, opts options) error {
+	ops, err := loadBatch(opts.batchFile, config, opts)
+	if err != nil {
+		return fmt.Errorf("loadBatch: %w", err)
+	}
+	saved := newBackup(config)
+	failed := 0
+	for i, op := range ops {
+		target := op.Date
+		if op.ID != "" {
+			target = op.ID
+		}
+		result, err := runBatchOp(client, config, opts, op, saved)
+		if err != nil {
+			fmt.Printf("%d %s %s: failed: %v\n", i+1, op.Op, target, err)
+			if opts.failFast {
+				return fmt.Errorf("operation %d failed, the ones before it were done", i+1)
+			}
+			failed++
+			continue
+		}
+		fmt.Printf("%d %s %s: %s\n", i+1, op.Op, target, result)
+	}
+	fmt.Printf("%d of %d operation(s) done\n", len(ops)-failed, len(ops))
+	if failed > 0 {
+		return fmt.Errorf("%d operation(s) failed", failed)
+	}
+	return nil
+}
+
+// runBatchOp runs one operation, returning what it did. Deleted events are added to
+// saved first.
+func runBatchOp(client *wfh.Client, config Config, opts options, op batchOp, saved *backup) (string, error) {
+	if op.ID != "" {
+		if opts.dryRun {
+			return "would delete", nil
+		}
+		item, err := client.Get(op.ID)
+		if err != nil {
+			return "", fmt.Errorf("client.Get: %w", err)
+		}
+		err = saved.add(client.CalendarID(), item)
+		if err != nil {
+			return "", fmt.Errorf("backup: %w", err)
+		}
+		err = client.Delete(op.ID)
+		if err != nil {
+			return "", fmt.Errorf("client.Delete: %w", err)
+		}
+		config.logEvent("deleted", item)
+		return "deleted", nil
+	}
+	if op.Op == "delete" {
+		existing, err := client.FindWFH(wfh.Day(op.day), config.DefaultMessage)
+		if err != nil {
+			return "", fmt.Errorf("client.FindWFH: %w", err)
+		}
+		if opts.dryRun {
+			return fmt.Sprintf("would delete %d WFH event(s)", len(existing)), nil
+		}
+		err = saved.add(client.CalendarID(), existing...)
+		if err != nil {
+			return "", fmt.Errorf("backup: %w", err)
+		}
+		for _, item := range existing {
+			err := client.Delete(item.Id)
+			if err != nil {
+				return "", fmt.Errorf("client.Delete: %w", err)
+			}
+			config.logEvent("deleted", item)
+		}
+		return fmt.Sprintf("deleted %d WFH event(s)", len(existing)), nil
+	}
+	bookOpts := bookOptions(opts)
+	bookOpts.ColorID = config.dayColor(opts, op.day)
+	if op.Message != "" {
+		bookOpts.Message = withEmoji(config.SummaryEmoji, config.normalizeSummary(op.Message))
+		if opts.colorFromMessage {
+			bookOpts.ColorID = config.messageColor(bookOpts.Message)
+		}
+	}
+	if op.ColorID != 0 {
+		bookOpts.ColorID = op.ColorID
+	}
+	if op.Description != "" {
+		bookOpts.Description = op.Description
+	}
+	bookOpts, err := withDescription(config, bookOpts, op.day)
+	if err != nil {
+		return "", fmt.Errorf("withDescription: %w", err)
+	}
+	if opts.dryRun {
+		return fmt.Sprintf("would book %s", bookOpts.Message), nil
+	}
+	event, err := client.Book(op.day, bookOpts)
+	if err != nil {
+		return "", fmt.Errorf("client.Book: %w", err)
+	}
+	afterBooking(config, opts, event)
+	return fmt.Sprintf("booked %s, id %s", event.Summary, event.Id), nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// eventCache is a local copy of calendar events, so listings work offline.
+//
+// Events are kept per calendar and day. Syncing a range replaces everything cached for
+// the days in it, so events deleted in the calendar disappear from the cache too. Days
+// that were never synced are unknown: reading them is an error, not an empty day. Each
+// day remembers when it was synced, and offline listings say how old the data is.
+//
+// A full sync also stores a sync token, if the calendar hands one out. Later syncs of a
+// range within the token's range fetch only what changed since.
+type eventCache struct {
+	Calendars map[string]*cachedEvents `json:"calendars"`
+}
+
+type cachedEvents struct {
+	// Days maps YYYY-MM-DD to the events starting that day.
+	Days map[string][]*calendar.Event `json:"days"`
+	// SyncedAt maps YYYY-MM-DD to when the day was last synced.
+	SyncedAt map[string]time.Time `json:"synced_at"`
+	// SyncToken gets the changes since the last sync of SyncFrom to SyncTo, YYYY-MM-DD.
+	SyncToken string `json:"sync_token,omitempty"`
+	SyncFrom  string `json:"sync_from,omitempty"`
+	SyncTo    string `json:"sync_to,omitempty"`
+}
+
+const eventCacheFile = "events-cache.json"
+
+func loadEventCache(configPath string) (*eventCache, error) {
+	cache := &eventCache{Calendars: make(map[string]*cachedEvents)}
+	b, err := os.ReadFile(filepath.Join(configPath, eventCacheFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	err = json.Unmarshal(b, cache)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	if cache.Calendars == nil {
+		cache.Calendars = make(map[string]*cachedEvents)
+	}
+	return cache, nil
+}
+
+func (cache *eventCache) save(configPath string) error {
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(configPath, eventCacheFile), b, 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+// calendar returns the cached events of a calendar, creating an empty entry if needed.
+func (cache *eventCache) calendar(calendarID string) *cachedEvents {
+	cal, ok := cache.Calendars[calendarID]
+	if !ok {
+		cal = &cachedEvents{Days: make(map[string][]*calendar.Event), SyncedAt: make(map[string]time.Time)}
+		cache.Calendars[calendarID] = cal
+	}
+	return cal
+}
+
+// store replaces the cached events for every day in the range.
+func (cache *eventCache) store(calendarID string, r wfh.Range, items []*calendar.Event, now time.Time) {
+	cal := cache.calendar(calendarID)
+	for day := r.From; !day.After(r.To); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		cal.Days[key] = []*calendar.Event{}
+		cal.SyncedAt[key] = now
+	}
+	for _, item := range items {
+		key := wfh.EventDate(item)
+		cal.Days[key] = append(cal.Days[key], item)
+	}
+}
+
+// apply updates the cache with the changes from an incremental sync, and marks the days
+// in the range as synced. Changes on days that were never synced are left out, those
+// days stay unknown.
+func (cache *eventCache) apply(calendarID string, r wfh.Range, changes []*calendar.Event, now time.Time) {
+	cal := cache.calendar(calendarID)
+	for _, change := range changes {
+		// the event may have moved, so drop it wherever it was.
+		for key, items := range cal.Days {
+			cal.Days[key] = slices.DeleteFunc(items, func(item *calendar.Event) bool {
+				return item.Id == change.Id
+			})
+		}
+		key := wfh.EventDate(change)
+		if _, ok := cal.SyncedAt[key]; change.Status == "cancelled" || !ok {
+			continue
+		}
+		cal.Days[key] = append(cal.Days[key], change)
+	}
+	for day := r.From; !day.After(r.To); day = day.AddDate(0, 0, 1) {
+		cal.SyncedAt[day.Format("2006-01-02")] = now
+	}
+}
+
+// tokenRange returns the range the calendar's sync token covers, if it has one that
+// covers r.
+func (cal *cachedEvents) tokenRange(r wfh.Range, loc *time.Location) (wfh.Range, bool) {
+	if cal == nil || cal.SyncToken == "" {
+		return wfh.Range{}, false
+	}
+	from, err := time.ParseInLocation("2006-01-02", cal.SyncFrom, loc)
+	if err != nil {
+		return wfh.Range{}, false
+	}
+	to, err := time.ParseInLocation("2006-01-02", cal.SyncTo, loc)
+	if err != nil {
+		return wfh.Range{}, false
+	}
+	if r.From.Format("2006-01-02") < cal.SyncFrom || r.To.Format("2006-01-02") > cal.SyncTo {
+		return wfh.Range{}, false
+	}
+	return wfh.Range{From: from, To: to}, true
+}
+
+// lister returns an eventLister reading from the cache.
+func (cache *eventCache) lister(calendarID string) eventLister {
+	return cacheLister{events: cache.Calendars[calendarID]}
+}
+
+type cacheLister struct {
+	events *cachedEvents
+}
+
+// List returns the cached events in the range. It fails if any day in the range
+// hasn't been synced, and prints how old the data is.
+func (l cacheLister) List(r wfh.Range, opts wfh.ListOptions) ([]*calendar.Event, error) {
+	if l.events == nil {
+		return nil, fmt.Errorf("nothing cached for this calendar, run wfh -sync first")
+	}
+	var items []*calendar.Event
+	var oldest time.Time
+	for day := r.From; !day.After(r.To); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		syncedAt, ok := l.events.SyncedAt[key]
+		if !ok {
+			return nil, fmt.Errorf("%s isn't cached, run wfh -sync -from %s -to %s", key, key, r.To.Format("2006-01-02"))
+		}
+		if oldest.IsZero() || syncedAt.Before(oldest) {
+			oldest = syncedAt
+		}
+		for _, item := range l.events.Days[key] {
+			if opts.Text == "" || wfh.MatchesText(item, opts.Text) {
+				items = append(items, item)
+			}
+		}
+	}
+	// on stderr, so it stays out of -raw JSON and -out files.
+	_, _ = fmt.Fprintf(os.Stderr, "Using cached events, synced %s (%s ago)\n",
+		oldest.Local().Format("2006-01-02 15:04"), time.Since(oldest).Round(time.Minute))
+	if opts.OrderBy == wfh.OrderUpdated {
+		slices.SortStableFunc(items, func(a, b *calendar.Event) int {
+			return strings.Compare(a.Updated, b.Updated)
+		})
+	} else {
+		// syncs don't order, and incremental ones append to the day.
+		slices.SortStableFunc(items, func(a, b *calendar.Event) int {
+			return strings.Compare(startKey(a), startKey(b))
+		})
+	}
+	if opts.Reverse {
+		slices.Reverse(items)
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, nil
+}
+
+// startKey sorts all-day events before the timed events of the same day.
+func startKey(item *calendar.Event) string {
+	if item.Start == nil {
+		return ""
+	}
+	if item.Start.Date != "" {
+		return item.Start.Date
+	}
+	return item.Start.DateTime
+}
+
+// syncCache fetches the events in the requested range and stores them in the cache.
+// If a previous sync left a token covering the range, only the changes since are fetched.
+func syncCache(client *wfh.Client, config Config, configPath string, opts options) error {
+	r := wfh.Range{From: opts.from, To: opts.to}
+	cache, err := loadEventCache(configPath)
+	if err != nil {
+		return fmt.Errorf("loadEventCache: %w", err)
+	}
+	cal := cache.Calendars[client.CalendarID()]
+	if tokenRange, ok := cal.tokenRange(r, config.Location()); ok {
+		changes, token, err := client.SyncChanges(cal.SyncToken)
+		switch {
+		case errors.Is(err, wfh.ErrSyncTokenExpired):
+			fmt.Println("The sync token has expired, syncing everything again")
+			cal.SyncToken = ""
+		case err != nil:
+			return fmt.Errorf("client.SyncChanges: %w", err)
+		default:
+			cache.apply(client.CalendarID(), tokenRange, changes, config.now())
+			cal.SyncToken = token
+			err = cache.save(configPath)
+			if err != nil {
+				return fmt.Errorf("cache.save: %w", err)
+			}
+			fmt.Printf("Synced %d changed events from %s to %s\n", len(changes),
+				cal.SyncFrom, cal.SyncTo)
+			return nil
+		}
+	}
+	items, token, err := client.SyncAll(r)
+	if err != nil {
+		return fmt.Errorf("client.SyncAll: %w", err)
+	}
+	cache.store(client.CalendarID(), r, items, config.now())
+	cal = cache.Calendars[client.CalendarID()]
+	cal.SyncToken, cal.SyncFrom, cal.SyncTo = token, r.From.Format("2006-01-02"), r.To.Format("2006-01-02")
+	err = cache.save(configPath)
+	if err != nil {
+		return fmt.Errorf("cache.save: %w", err)
+	}
+	fmt.Printf("Cached %d events from %s to %s\n", len(items), r.From.Format("2006-01-02"), r.To.Format("2006-01-02"))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// eventColor is one of Google Calendar's event colors.
+type eventColor struct {
+	name    string
+	r, g, b uint8
+}
+
+// eventColors are the event colors by ID, with the names and shades Google Calendar
+// shows. They're fixed, so there's no need to ask the Colors API.
+var eventColors = []eventColor{
+	1:  {"Lavender", 0x79, 0x86, 0xcb},
+	2:  {"Sage", 0x33, 0xb6, 0x79},
+	3:  {"Grape", 0x8e, 0x24, 0xaa},
+	4:  {"Flamingo", 0xe6, 0x7c, 0x73},
+	5:  {"Banana", 0xf6, 0xbf, 0x26},
+	6:  {"Tangerine", 0xf4, 0x51, 0x1e},
+	7:  {"Peacock", 0x03, 0x9b, 0xe5},
+	8:  {"Graphite", 0x61, 0x61, 0x61},
+	9:  {"Blueberry", 0x3f, 0x51, 0xb5},
+	10: {"Basil", 0x0b, 0x80, 0x43},
+	11: {"Tomato", 0xd5, 0x00, 0x00},
+}
+
+// printColorLegend prints the color IDs with their names. On a terminal each line gets
+// a swatch of the color. Names from color_names are shown next to Google's.
+func printColorLegend(w io.Writer, config Config, tty bool) {
+	for id := 1; id < len(eventColors); id++ {
+		c := eventColors[id]
+		swatch := ""
+		if tty {
+			swatch = fmt.Sprintf("\x1b[48;2;%d;%d;%dm    \x1b[0m ", c.r, c.g, c.b)
+		}
+		_, _ = fmt.Fprintf(w, "%s%2d  %s", swatch, id, c.name)
+		if name, ok := config.ColorNames[strconv.Itoa(id)]; ok && name != c.name {
+			_, _ = fmt.Fprintf(w, " (%s)", name)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+}
+
+// colorCycleFile remembers where in color_cycle the next booking is.
+const colorCycleFile = "color-cycle.json"
+
+type colorCycle struct {
+	// Next is the index in color_cycle of the next color.
+	Next int `json:"next"`
+}
+
+// loadColorCycle returns the index in color_cycle of the next color, it's up to the
+// caller to keep it within the cycle. A missing or broken file starts the cycle over.
+func loadColorCycle(configPath string) int {
+	b, err := os.ReadFile(filepath.Join(configPath, colorCycleFile))
+	if err != nil {
+		return 0
+	}
+	var cycle colorCycle
+	err = json.Unmarshal(b, &cycle)
+	if err != nil || cycle.Next < 0 {
+		return 0
+	}
+	return cycle.Next
+}
+
+// advanceColorCycle moves color_cycle on to the next color. Failing to doesn't fail the
+// booking, the color just repeats.
+func (c Config) advanceColorCycle() {
+	if len(c.ColorCycle) == 0 {
+		return
+	}
+	next := (loadColorCycle(c.dir) + 1) % len(c.ColorCycle)
+	b, err := json.Marshal(colorCycle{Next: next})
+	if err != nil {
+		log.Printf("Unable to advance color_cycle: %v", err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(c.dir, colorCycleFile), b, 0600)
+	if err != nil {
+		log.Printf("Unable to advance color_cycle: %v", err)
+	}
+}
+
+// messageColor returns the color ID the message hashes to, so every WFH reason keeps a
+// color of its own. The summary emoji is left out, it's the same on all of them.
+func (c Config) messageColor(message string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.TrimSpace(strings.TrimPrefix(message, c.SummaryEmoji))))
+	return int(h.Sum32()%wfh.MaxColorID) + 1
+}
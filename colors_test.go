@@ -0,0 +1,29 @@
+package main
+
+import (
+	calendar "google.golang.org/api/calendar/v3"
+	"testing"
+	"time"
+)
+
+func TestColorCycleAdvances(t *testing.T) {
+	config := Config{dir: t.TempDir(), location: time.UTC, ColorCycle: []int{3, 5, 7}}
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	event := &calendar.Event{Id: "a", Summary: "WFH", Start: &calendar.EventDateTime{Date: "2024-06-03"}}
+	tests := []struct {
+		name string
+		opts options
+		want int // the next color after the booking
+	}{
+		{"picked by the cycle", options{noNotify: true}, 5},
+		{"-color given", options{noNotify: true, color: 9, colorGiven: true}, 5},
+		{"color_from_message", options{noNotify: true, message: "WFH", colorFromMessage: true}, 5},
+		{"picked by the cycle again", options{noNotify: true}, 7},
+	}
+	for _, tt := range tests {
+		afterBooking(config, tt.opts, event)
+		if got := config.dayColor(options{}, monday); got != tt.want {
+			t.Errorf("%s: the next color is %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
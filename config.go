@@ -0,0 +1,655 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+type Config struct {
+	CalendarID     string `json:"calendar_id"`
+	DefaultMessage string `json:"default_message"`
+	User           string `json:"user"`
+	OfficeMessage  string `json:"office_message"`
+	Timezone       string `json:"timezone"`
+	BackfillDays   int    `json:"backfill_days"`
+	// DefaultDuration is how long timed events booked with -start-time last, like "8h".
+	DefaultDuration string `json:"default_duration"`
+	// MarkerDuration is how long the marker events booked with -at last, 15m if unset.
+	MarkerDuration string `json:"marker_duration"`
+	// MaxFutureDays is how far ahead a day can be booked without -force.
+	MaxFutureDays int `json:"max_future_days"`
+	// MinNoticeDays is how many days ahead a day must be booked without -force.
+	MinNoticeDays int `json:"min_notice_days"`
+	// WorkingDays are the days of the week that are booked when booking a range, like
+	// "Monday" or "Mon". Defaults to Monday to Friday.
+	WorkingDays []string `json:"working_days"`
+	// SkipSummaries are summaries of events, like "Vacation", that keep a range booking
+	// from booking WFH on the days they cover.
+	SkipSummaries []string `json:"skip_summaries"`
+	// MatchMode is how summaries of WFH events booked before wfh tagged them are
+	// matched: "exact", "ci" (the default) or "contains".
+	MatchMode string `json:"match_mode"`
+	// ConflictKeywords mark events, like "onsite", that clash with working from home.
+	// Booking a day with one of them needs -force.
+	ConflictKeywords []string `json:"conflict_keywords"`
+	// HolidaysFile is a CSV file of date,name lines. The days in it are skipped when
+	// booking more than one day.
+	HolidaysFile string `json:"holidays_file"`
+	// Calendars maps short names, usable with -calendar, to calendar IDs, or to a
+	// CalendarEntry for calendars in another time zone.
+	Calendars map[string]CalendarEntry `json:"calendars"`
+	// MirrorCalendars get a copy of every WFH day booked, like a shared team calendar.
+	// Names from calendars, calendar names and IDs all work.
+	MirrorCalendars []string `json:"mirror_calendars"`
+	// Reminders replace the calendar's default reminders on created events.
+	Reminders []Reminder `json:"reminders"`
+	// NormalizeSummary is how messages are cleaned up before booking: "trim" trims and
+	// collapses spaces, "upper", "lower" and "title" change the case as well.
+	NormalizeSummary string `json:"normalize_summary"`
+	// SummaryEmoji is put in front of the summary of WFH events.
+	SummaryEmoji string `json:"summary_emoji"`
+	// ColorNames gives color IDs a name in listings.
+	ColorNames map[string]string `json:"color_names"`
+	// ColorsByWeekday maps days of the week to the color ID WFH events on that day get,
+	// unless -color is given.
+	ColorsByWeekday map[string]int `json:"colors_by_weekday"`
+	// ColorCycle are color IDs WFH events get in turn, instead of a random color.
+	ColorCycle []int `json:"color_cycle"`
+	// ColorFromMessage gives WFH events a color hashed from their summary, instead of
+	// the colors above.
+	ColorFromMessage bool `json:"color_from_message"`
+	// ServiceAccountFile is a service account key used instead of the browser login.
+	ServiceAccountFile string `json:"service_account_file"`
+	// Defaults maps flag names to default values, overriding the built-in defaults.
+	Defaults map[string]any `json:"defaults"`
+	// DescriptionTemplate is a text/template for the description of WFH events.
+	DescriptionTemplate string `json:"description_template"`
+	// EventLocation is the location of WFH events, like "Home".
+	EventLocation string `json:"location"`
+	// Transparency is "transparent" to not block time with WFH events, or "opaque".
+	Transparency string `json:"transparency"`
+	// Provider is the calendar service, "google" (the default) or "microsoft".
+	Provider string `json:"provider"`
+	// MicrosoftClientID is the Azure app registration wfh logs in with.
+	MicrosoftClientID string `json:"microsoft_client_id"`
+	// MicrosoftTenant is the Azure tenant, "common" if unset.
+	MicrosoftTenant string `json:"microsoft_tenant"`
+	// AuthMode is how the login code gets to wfh: "local" (the default), "paste" or "oob".
+	AuthMode string `json:"auth_mode"`
+	// CallbackPorts are the ports the login may redirect to, in order of preference.
+	// The next one is used when a port is taken.
+	CallbackPorts []int `json:"callback_ports"`
+	// SlackWebhookURL is a Slack incoming webhook told about WFH bookings.
+	SlackWebhookURL string `json:"slack_webhook_url"`
+	// Webhook is called after booking, for anything Slack's webhook doesn't cover.
+	Webhook *Webhook `json:"webhook"`
+	// DeterministicIDs gives WFH events an ID made from the user and the day, so the
+	// status of a day is a single fetch rather than a listing. Google only.
+	DeterministicIDs bool `json:"deterministic_ids"`
+	// EventLog records created and deleted events in events.log in the config directory.
+	EventLog bool `json:"event_log"`
+	// EventLogMaxSize is the size in bytes events.log is rotated at.
+	EventLogMaxSize int64 `json:"event_log_max_size"`
+
+	// dir is the config directory the config was read from.
+	dir                 string
+	location            *time.Location
+	descriptionTemplate *template.Template
+	defaultDuration     time.Duration
+	markerDuration      time.Duration
+	workingDays         map[time.Weekday]bool
+	weekdayColors       map[time.Weekday]int
+	holidays            map[string]string
+	// baseFile is the base config merged in, empty if there is none.
+	baseFile string
+}
+
+// CalendarEntry is a calendar in the calendars map. In the config it's either just the
+// calendar ID, or an object with the ID and the time zone to book in it with.
+type CalendarEntry struct {
+	ID string `json:"id"`
+	// Timezone overrides timezone for this calendar.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// UnmarshalJSON accepts a plain calendar ID as well as an object.
+func (e *CalendarEntry) UnmarshalJSON(b []byte) error {
+	var id string
+	if json.Unmarshal(b, &id) == nil {
+		*e = CalendarEntry{ID: id}
+		return nil
+	}
+	// a type without the method, so this doesn't recurse.
+	type entry CalendarEntry
+	return json.Unmarshal(b, (*entry)(e))
+}
+
+// Calendar services wfh can talk to.
+const (
+	providerGoogle    = "google"
+	providerMicrosoft = "microsoft"
+)
+
+// Webhook is an HTTP request made after booking a WFH day.
+type Webhook struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// Body is a text/template, see webhookData.
+	Body string `json:"body"`
+
+	body *template.Template
+}
+
+// Reminder is a reminder to put on created events.
+type Reminder struct {
+	Method  string `json:"method"`
+	Minutes int64  `json:"minutes"`
+}
+
+// maxReminderMinutes is the furthest ahead Google allows a reminder, four weeks.
+const maxReminderMinutes = 40320
+
+// validate checks the reminder against what Google accepts.
+func (r Reminder) validate() error {
+	if r.Method != "email" && r.Method != "popup" {
+		return fmt.Errorf("reminder method must be email or popup, not %q", r.Method)
+	}
+	if r.Minutes < 0 || r.Minutes > maxReminderMinutes {
+		return fmt.Errorf("reminder minutes must be between 0 and %d, not %d", maxReminderMinutes, r.Minutes)
+	}
+	return nil
+}
+
+// Location returns the configured time zone, or time.Local if none is set.
+func (c Config) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+// clock returns the current time. Relative dates, default dates and the
+// max_future_days horizon are resolved against it, so tests can stop it.
+var clock = time.Now
+
+// now returns the current time in the configured time zone.
+func (c Config) now() time.Time {
+	return clock().In(c.Location())
+}
+
+// getConfigPath returns the config directory: $WFH_CONFIG_DIR if set, otherwise ~/.wfh.
+func getConfigPath() (string, error) {
+	if dir := os.Getenv("WFH_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find your home directory (%w), set WFH_CONFIG_DIR to the directory holding config.json", err)
+	}
+	return filepath.Join(homeDir, ".wfh"), nil
+}
+
+// defaultBaseConfig is the config shared by everyone on the machine, like the defaults
+// an organization ships. $WFH_BASE_CONFIG points to another file.
+const defaultBaseConfig = "/etc/wfh/config.json"
+
+func getBaseConfigPath() string {
+	if path := os.Getenv("WFH_BASE_CONFIG"); path != "" {
+		return path
+	}
+	return defaultBaseConfig
+}
+
+func getConfig(path string) (Config, error) {
+	var config Config
+	configPath := filepath.Join(path, "config.json")
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, fmt.Errorf("os.ReadFile(%s): %w", configPath, err)
+	}
+	basePath := getBaseConfigPath()
+	base, err := os.ReadFile(basePath)
+	switch {
+	case os.IsNotExist(err):
+		basePath = ""
+	case err != nil:
+		return Config{}, fmt.Errorf("os.ReadFile(%s): %w", basePath, err)
+	default:
+		b, err = mergeConfig(base, b)
+		if err != nil {
+			return Config{}, fmt.Errorf("mergeConfig(%s, %s): %w", basePath, configPath, err)
+		}
+	}
+
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("json.Unmarshal(%s): %w", configPath, err)
+	}
+	config.dir = path
+	config.baseFile = basePath
+	if strings.TrimSpace(config.DefaultMessage) == "" {
+		// an event without a summary is blank in the calendar, and can't be told apart.
+		config.DefaultMessage = defaultMessage
+	}
+	if config.DescriptionTemplate != "" {
+		config.descriptionTemplate, err = template.New("description").Parse(config.DescriptionTemplate)
+		if err != nil {
+			return Config{}, fmt.Errorf("description_template: %w", err)
+		}
+	}
+	if config.Webhook != nil {
+		config.Webhook.body, err = template.New("webhook").Funcs(webhookFuncs).Parse(config.Webhook.Body)
+		if err != nil {
+			return Config{}, fmt.Errorf("webhook body: %w", err)
+		}
+	}
+	if config.HolidaysFile != "" {
+		config.holidays, err = loadHolidays(path, config.HolidaysFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("holidays_file: %w", err)
+		}
+	}
+	if config.DefaultDuration != "" {
+		config.defaultDuration, err = time.ParseDuration(config.DefaultDuration)
+		if err != nil {
+			return Config{}, fmt.Errorf("default_duration: %w", err)
+		}
+	}
+	if config.MarkerDuration != "" {
+		config.markerDuration, err = time.ParseDuration(config.MarkerDuration)
+		if err != nil {
+			return Config{}, fmt.Errorf("marker_duration: %w", err)
+		}
+	}
+	if len(config.WorkingDays) > 0 {
+		config.workingDays = make(map[time.Weekday]bool)
+		for _, name := range config.WorkingDays {
+			day, ok := parseWeekday(name)
+			if !ok {
+				return Config{}, fmt.Errorf("working_days: %q isn't a day of the week", name)
+			}
+			config.workingDays[day] = true
+		}
+	}
+	if len(config.ColorsByWeekday) > 0 {
+		config.weekdayColors = make(map[time.Weekday]int)
+		for name, colorID := range config.ColorsByWeekday {
+			day, ok := parseWeekday(name)
+			if !ok {
+				return Config{}, fmt.Errorf("colors_by_weekday: %q isn't a day of the week", name)
+			}
+			config.weekdayColors[day] = colorID
+		}
+	}
+	if config.Timezone != "" {
+		config.location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			return Config{}, fmt.Errorf("time.LoadLocation(%s): %w", config.Timezone, err)
+		}
+	}
+	for name, entry := range config.Calendars {
+		if entry.Timezone == "" {
+			continue
+		}
+		_, err = time.LoadLocation(entry.Timezone)
+		if err != nil {
+			return Config{}, fmt.Errorf("calendars: %s: time.LoadLocation(%s): %w", name, entry.Timezone, err)
+		}
+	}
+
+	return config, nil
+}
+
+// parseWeekday parses the English name of a day of the week, in full or abbreviated
+// to three letters, ignoring case.
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) || strings.EqualFold(name, day.String()[:3]) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// isWorkingDay reports whether the day is one of working_days, Monday to Friday if it
+// isn't set.
+func (c Config) isWorkingDay(day time.Weekday) bool {
+	if c.workingDays == nil {
+		return day != time.Saturday && day != time.Sunday
+	}
+	return c.workingDays[day]
+}
+
+// dayColor returns the color ID to book the day with: -color if given, then the color
+// of the message with color_from_message, then the day's color from colors_by_weekday,
+// then the default color. Without one, it's the next color from color_cycle, or zero
+// for a random one.
+func (c Config) dayColor(opts options, day time.Time) int {
+	if opts.colorGiven {
+		return opts.color
+	}
+	if opts.colorFromMessage {
+		return c.messageColor(opts.message)
+	}
+	if colorID, ok := c.weekdayColors[day.Weekday()]; ok {
+		return colorID
+	}
+	if c.usesColorCycle(opts, day) {
+		return c.ColorCycle[loadColorCycle(c.dir)%len(c.ColorCycle)]
+	}
+	return opts.color
+}
+
+// usesColorCycle reports whether dayColor takes the day's color from color_cycle, and
+// the cycle should move on after the booking.
+func (c Config) usesColorCycle(opts options, day time.Time) bool {
+	if opts.colorGiven || opts.colorFromMessage || opts.color != 0 || len(c.ColorCycle) == 0 {
+		return false
+	}
+	_, ok := c.weekdayColors[day.Weekday()]
+	return !ok
+}
+
+// calendarEntry returns the entry in calendars for a -calendar argument or calendar ID.
+func (c Config) calendarEntry(name string) (CalendarEntry, bool) {
+	if name == "" {
+		name = c.CalendarID
+	}
+	if entry, ok := c.Calendars[name]; ok {
+		return entry, true
+	}
+	for _, entry := range c.Calendars {
+		if entry.ID == name {
+			return entry, true
+		}
+	}
+	return CalendarEntry{}, false
+}
+
+// calendarLocation returns the time zone to book in the calendar with, its own from
+// calendars if it has one, or the configured one.
+func (c Config) calendarLocation(name string) *time.Location {
+	if entry, ok := c.calendarEntry(name); ok && entry.Timezone != "" {
+		// checked by getConfig.
+		if loc, err := time.LoadLocation(entry.Timezone); err == nil {
+			return loc
+		}
+	}
+	return c.Location()
+}
+
+// clientOptions returns the options for a client of a calendar in loc.
+func (c Config) clientOptions(loc *time.Location) []wfh.Option {
+	opts := []wfh.Option{wfh.WithLocation(loc), wfh.WithMatchMode(c.MatchMode)}
+	if c.DeterministicIDs {
+		opts = append(opts, wfh.WithDeterministicIDs(c.user()))
+	}
+	return opts
+}
+
+// forCalendar returns the config to use with the calendar, with its time zone from
+// calendars in place of the configured one.
+func (c Config) forCalendar(name string) Config {
+	if entry, ok := c.calendarEntry(name); ok && entry.Timezone != "" {
+		c.Timezone = entry.Timezone
+		c.location = c.calendarLocation(name)
+	}
+	return c
+}
+
+// calendarID resolves a -calendar argument. Names from the calendars map are looked
+// up, anything else is taken to be a calendar ID. Empty means the default calendar.
+func (c Config) calendarID(name string) string {
+	if name == "" {
+		return c.CalendarID
+	}
+	if entry, ok := c.Calendars[name]; ok {
+		return entry.ID
+	}
+	return name
+}
+
+// validate checks the config for values Google won't accept.
+func (c Config) validate() error {
+	if !utf8.ValidString(c.SummaryEmoji) {
+		return fmt.Errorf("summary_emoji is not valid UTF-8")
+	}
+	if c.Transparency != "" && c.Transparency != "transparent" && c.Transparency != "opaque" {
+		return fmt.Errorf("transparency must be transparent or opaque, not %q", c.Transparency)
+	}
+	if !slices.Contains([]string{"", "trim", "upper", "lower", "title"}, c.NormalizeSummary) {
+		return fmt.Errorf("normalize_summary must be trim, upper, lower or title, not %q", c.NormalizeSummary)
+	}
+	if c.DeterministicIDs && c.Provider == providerMicrosoft {
+		return fmt.Errorf("deterministic_ids only works with Google, Graph assigns the IDs itself")
+	}
+	if c.Webhook != nil && c.Webhook.URL == "" {
+		return fmt.Errorf("webhook: url is required")
+	}
+	if c.DefaultDuration != "" {
+		err := checkDuration(c.defaultDuration)
+		if err != nil {
+			return fmt.Errorf("default_duration: %w", err)
+		}
+	}
+	if c.MarkerDuration != "" {
+		err := checkDuration(c.markerDuration)
+		if err != nil {
+			return fmt.Errorf("marker_duration: %w", err)
+		}
+	}
+	if c.MaxFutureDays < 0 {
+		return fmt.Errorf("max_future_days must not be negative")
+	}
+	if c.MinNoticeDays < 0 {
+		return fmt.Errorf("min_notice_days must not be negative")
+	}
+	if c.EventLogMaxSize < 0 {
+		return fmt.Errorf("event_log_max_size must not be negative")
+	}
+	for id := range c.ColorNames {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 1 || n > wfh.MaxColorID {
+			return fmt.Errorf("color_names: color IDs are 1 to %d, not %q", wfh.MaxColorID, id)
+		}
+	}
+	for name, colorID := range c.ColorsByWeekday {
+		if colorID < 1 || colorID > wfh.MaxColorID {
+			return fmt.Errorf("colors_by_weekday: color IDs are 1 to %d, not %d for %s", wfh.MaxColorID, colorID, name)
+		}
+	}
+	for _, colorID := range c.ColorCycle {
+		if colorID < 1 || colorID > wfh.MaxColorID {
+			return fmt.Errorf("color_cycle: color IDs are 1 to %d, not %d", wfh.MaxColorID, colorID)
+		}
+	}
+	for _, port := range c.CallbackPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("callback_ports: %d isn't a port", port)
+		}
+	}
+	switch c.MatchMode {
+	case "", wfh.MatchExact, wfh.MatchCaseInsensitive, wfh.MatchContains:
+	default:
+		return fmt.Errorf("match_mode must be %s, %s or %s, not %q", wfh.MatchExact, wfh.MatchCaseInsensitive, wfh.MatchContains, c.MatchMode)
+	}
+	switch c.AuthMode {
+	case "", authLocal, authPaste, authOOB:
+	default:
+		return fmt.Errorf("auth_mode must be %s, %s or %s, not %q", authLocal, authPaste, authOOB, c.AuthMode)
+	}
+	switch c.Provider {
+	case "", providerGoogle:
+	case providerMicrosoft:
+		if c.MicrosoftClientID == "" {
+			return fmt.Errorf("microsoft_client_id is required with provider %q", providerMicrosoft)
+		}
+	default:
+		return fmt.Errorf("provider must be %s or %s, not %q", providerGoogle, providerMicrosoft, c.Provider)
+	}
+	for _, r := range c.Reminders {
+		err := r.validate()
+		if err != nil {
+			return fmt.Errorf("reminders: %w", err)
+		}
+	}
+	return nil
+}
+
+// redactedValue is what secrets are replaced with in -print-config.
+const redactedValue = "REDACTED"
+
+// redacted returns a copy of the config without the secrets in it, so it can be shared.
+// Webhook URLs carry their token in the path or query, only the host is kept.
+func (c Config) redacted() Config {
+	c.SlackWebhookURL = redactURL(c.SlackWebhookURL)
+	if c.Webhook != nil {
+		webhook := *c.Webhook
+		webhook.URL = redactURL(webhook.URL)
+		webhook.Headers = make(map[string]string, len(c.Webhook.Headers))
+		for name := range c.Webhook.Headers {
+			webhook.Headers[name] = redactedValue
+		}
+		c.Webhook = &webhook
+	}
+	return c
+}
+
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return redactedValue
+	}
+	return u.Scheme + "://" + u.Host + "/" + redactedValue
+}
+
+// mergeConfig merges the user's config over the base config. Objects, like calendars or
+// defaults, are merged key by key, so a user can add to them without repeating the base;
+// any other value the user sets replaces the base one.
+func mergeConfig(base, user []byte) ([]byte, error) {
+	var baseRaw, userRaw map[string]any
+	err := json.Unmarshal(base, &baseRaw)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(base): %w", err)
+	}
+	err = json.Unmarshal(user, &userRaw)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(user): %w", err)
+	}
+	b, err := json.Marshal(mergeObjects(baseRaw, userRaw))
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	return b, nil
+}
+
+func mergeObjects(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		baseObject, ok1 := merged[key].(map[string]any)
+		object, ok2 := value.(map[string]any)
+		if ok1 && ok2 {
+			merged[key] = mergeObjects(baseObject, object)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// saveCalendarID sets calendar_id in the config file, leaving the rest of it alone.
+func saveCalendarID(path string, calendarID string) error {
+	configPath := filepath.Join(path, "config.json")
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile(%s): %w", configPath, err)
+	}
+	// a generic map keeps fields this version of wfh doesn't know about.
+	var raw map[string]any
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return fmt.Errorf("json.Unmarshal(%s): %w", configPath, err)
+	}
+	raw["calendar_id"] = calendarID
+	b, err = json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	err = os.WriteFile(configPath, append(b, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile(%s): %w", configPath, err)
+	}
+	return nil
+}
+
+// calendarCache remembers facts about calendars so we don't have to look them up every run.
+type calendarCache map[string]cachedCalendar
+
+type cachedCalendar struct {
+	TimeZone string `json:"timezone,omitempty"`
+	// Summary is the name of the calendar, for resolving names to IDs.
+	Summary string `json:"summary,omitempty"`
+}
+
+// primaryCalendar is the calendar ID both Google and Graph take for the user's own
+// calendar. It's passed through as it is, even if a calendar is named "primary".
+const primaryCalendar = "primary"
+
+// lookup returns the ID of the calendar with the given ID or name, if the cache knows
+// it unambiguously.
+func (cache calendarCache) lookup(name string) (string, bool) {
+	if name == primaryCalendar {
+		return name, true
+	}
+	if _, ok := cache[name]; ok {
+		return name, true
+	}
+	id, matches := "", 0
+	for calendarID, cached := range cache {
+		if cached.Summary == name {
+			id = calendarID
+			matches++
+		}
+	}
+	return id, matches == 1
+}
+
+func loadCalendarCache(path string) calendarCache {
+	cache := calendarCache{}
+	b, err := os.ReadFile(filepath.Join(path, "calendars.json"))
+	if err != nil {
+		return cache
+	}
+	// a broken cache is just an empty one, it'll be rewritten.
+	_ = json.Unmarshal(b, &cache)
+	return cache
+}
+
+func (cache calendarCache) save(path string) error {
+	b, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(path, "calendars.json"), b, 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestValidateColors(t *testing.T) {
+	// zero means random on the command line, but a configured color has to be a color.
+	tests := []struct {
+		colorID int
+		ok      bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{11, true},
+		{12, false},
+	}
+	for _, tt := range tests {
+		configs := map[string]Config{
+			"color_names":       {ColorNames: map[string]string{strconv.Itoa(tt.colorID): "Home"}},
+			"colors_by_weekday": {ColorsByWeekday: map[string]int{"monday": tt.colorID}},
+			"color_cycle":       {ColorCycle: []int{1, tt.colorID}},
+		}
+		for field, config := range configs {
+			err := config.validate()
+			if (err == nil) != tt.ok {
+				t.Errorf("%s with %d: got %v, want ok %t", field, tt.colorID, err, tt.ok)
+			}
+		}
+	}
+}
+
+func TestDefaultMessageFallback(t *testing.T) {
+	t.Setenv("WFH_BASE_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{`{"calendar_id": "primary"}`, "WFH"},
+		{`{"calendar_id": "primary", "default_message": ""}`, "WFH"},
+		{`{"calendar_id": "primary", "default_message": "  "}`, "WFH"},
+		{`{"calendar_id": "primary", "default_message": "Home office"}`, "Home office"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.config), 0600)
+		if err != nil {
+			t.Fatalf("os.WriteFile: %v", err)
+		}
+		config, err := getConfig(dir)
+		if err != nil {
+			t.Fatalf("getConfig(%s): %v", tt.config, err)
+		}
+		if config.DefaultMessage != tt.want {
+			t.Errorf("getConfig(%s) has default_message %q, want %q", tt.config, config.DefaultMessage, tt.want)
+		}
+	}
+}
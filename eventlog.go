@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultEventLogMaxSize is the size events.log is rotated at, unless configured.
+const defaultEventLogMaxSize = 1 << 20
+
+// eventLogPath returns where the event log is kept.
+func eventLogPath(configPath string) string {
+	return filepath.Join(configPath, "events.log")
+}
+
+// logEvent appends a line about a created or deleted event to events.log, if event_log
+// is enabled. Failing to log doesn't fail the command, the calendar has been changed
+// either way.
+func (c Config) logEvent(action string, event *calendar.Event) {
+	if !c.EventLog {
+		return
+	}
+	path := eventLogPath(c.dir)
+	maxSize := c.EventLogMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultEventLogMaxSize
+	}
+	err := rotateLog(path, maxSize)
+	if err != nil {
+		log.Printf("Unable to rotate %s: %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Unable to open %s: %v", path, err)
+		return
+	}
+	defer f.Close() // nolint: errcheck
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\t%q\n", c.now().Format(time.RFC3339),
+		action, wfh.EventDate(event), event.Id, event.Summary)
+	if err != nil {
+		log.Printf("Unable to write %s: %v", path, err)
+	}
+}
+
+// rotateLog renames the log to path.1, replacing the previous one, once it has grown
+// to maxSize bytes.
+func rotateLog(path string, maxSize int64) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("os.Stat: %w", err)
+	}
+	if fi.Size() < maxSize {
+		return nil
+	}
+	err = os.Rename(path, path+".1")
+	if err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// explainBooking describes in plain words what booking the days will do, for -explain.
+func explainBooking(config Config, opts options, days []time.Time, mirrors []string) string {
+	var b strings.Builder
+	kind := ""
+	if len(opts.recurrence) > 0 {
+		kind = "recurring "
+	}
+	_, _ = fmt.Fprintf(&b, "Will book a %sWFH event titled %q", kind, opts.message)
+	if opts.timed {
+		// 8h rather than 8h0m0s.
+		duration := opts.duration.String()
+		if strings.HasSuffix(duration, "m0s") {
+			duration = strings.TrimSuffix(duration, "0s")
+		}
+		if strings.HasSuffix(duration, "h0m") {
+			duration = strings.TrimSuffix(duration, "0m")
+		}
+		_, _ = fmt.Fprintf(&b, ", from %s for %s,", opts.startTime.Format("15:04"), duration)
+	} else {
+		_, _ = b.WriteString(", all day,")
+	}
+	switch {
+	case len(days) == 1:
+		_, _ = fmt.Fprintf(&b, " on %s", days[0].Format("Monday 2006-01-02"))
+	case len(days) > 1:
+		_, _ = fmt.Fprintf(&b, " on %d days, %s to %s", len(days), days[0].Format("Monday 2006-01-02"),
+			days[len(days)-1].Format("Monday 2006-01-02"))
+	}
+	if len(opts.recurrence) > 0 {
+		names := make([]string, 0, len(opts.every))
+		for _, day := range opts.every {
+			names = append(names, day.String())
+		}
+		_, _ = fmt.Fprintf(&b, ", repeating every %s", strings.Join(names, ", "))
+		if opts.count > 0 {
+			_, _ = fmt.Fprintf(&b, " for %d events", opts.count)
+		} else {
+			_, _ = fmt.Fprintf(&b, " until %s", opts.until.Format("2006-01-02"))
+		}
+	}
+	if opts.location != "" {
+		_, _ = fmt.Fprintf(&b, ", at %q", opts.location)
+	}
+	_, _ = fmt.Fprintf(&b, ", to calendar %q", calendarName(config, opts.calendarID))
+	colors := make(map[int]bool)
+	for _, day := range days {
+		colors[config.dayColor(opts, day)] = true
+	}
+	switch {
+	case len(colors) > 1:
+		_, _ = b.WriteString(", with colors by weekday")
+	case colors[0]:
+		_, _ = b.WriteString(", with a random color")
+	default:
+		for colorID := range colors {
+			_, _ = fmt.Fprintf(&b, ", with color %s", colorString(config, strconv.Itoa(colorID)))
+		}
+	}
+	if opts.description != "" || config.descriptionTemplate != nil {
+		_, _ = b.WriteString(" and a description")
+	}
+	_, _ = b.WriteString(".")
+	if opts.transparency == "transparent" {
+		_, _ = b.WriteString(" It won't block time.")
+	}
+	if len(mirrors) > 0 {
+		_, _ = fmt.Fprintf(&b, " It's copied to %d more calendar(s): %s.", len(mirrors), strings.Join(mirrors, ", "))
+	}
+	return b.String()
+}
@@ -2,7 +2,10 @@ module github.com/perbu/wfh
 
 go 1.21.0
 
-require google.golang.org/api v0.138.0
+require (
+	golang.org/x/oauth2 v0.12.0
+	google.golang.org/api v0.138.0
+)
 
 require (
 	cloud.google.com/go/compute v1.23.0 // indirect
@@ -16,7 +19,6 @@ require (
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/net v0.15.0 // indirect
-	golang.org/x/oauth2 v0.12.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
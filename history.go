@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyEntry is an event wfh created, so -undo-last-n can find it again.
+type historyEntry struct {
+	CalendarID string    `json:"calendar_id"`
+	EventID    string    `json:"event_id"`
+	Date       string    `json:"date"`
+	Summary    string    `json:"summary"`
+	Created    time.Time `json:"created"`
+}
+
+const historyFile = "history.json"
+
+// maxHistory is how many created events are remembered, the oldest are forgotten first.
+const maxHistory = 50
+
+// loadHistory returns the remembered events, oldest first.
+func loadHistory(configPath string) ([]historyEntry, error) {
+	b, err := os.ReadFile(filepath.Join(configPath, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var history []historyEntry
+	err = json.Unmarshal(b, &history)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return history, nil
+}
+
+func saveHistory(configPath string, history []historyEntry) error {
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	b, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(configPath, historyFile), b, 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+// addHistory remembers a created event. Like the event log, failing to remember it
+// doesn't fail the booking.
+func (c Config) addHistory(calendarID string, event *calendar.Event) {
+	history, err := loadHistory(c.dir)
+	if err != nil {
+		log.Printf("Unable to load %s, starting over: %v", historyFile, err)
+	}
+	history = append(history, historyEntry{
+		CalendarID: calendarID,
+		EventID:    event.Id,
+		Date:       wfh.EventDate(event),
+		Summary:    event.Summary,
+		Created:    c.now(),
+	})
+	err = saveHistory(c.dir, history)
+	if err != nil {
+		log.Printf("Unable to remember the booking for -undo-last-n: %v", err)
+	}
+}
+
+// undoLast deletes the n most recently created events, newest first.
+func undoLast(backend wfh.Backend, config Config, opts options) error {
+	history, err := loadHistory(config.dir)
+	if err != nil {
+		return fmt.Errorf("loadHistory: %w", err)
+	}
+	if len(history) == 0 {
+		fmt.Println("Nothing booked to undo.")
+		return nil
+	}
+	n := min(opts.undoLastN, len(history))
+	undo := history[len(history)-n:]
+	if !opts.force {
+		fmt.Printf("The following %d event(s) will be deleted:\n", n)
+		for i := len(undo) - 1; i >= 0; i-- {
+			fmt.Printf("  %s %s (%s)\n", undo[i].Date, undo[i].Summary, undo[i].CalendarID)
+		}
+		if !confirm("Proceed?") {
+			return fmt.Errorf("aborted by user")
+		}
+	}
+	saved := newBackup(config)
+	// events deleted some other way, Google keeps them around as cancelled for a while.
+	gone := make(map[string]bool)
+	for _, entry := range undo {
+		item, err := backend.Get(entry.CalendarID, entry.EventID)
+		if errors.Is(err, wfh.ErrNotFound) || (err == nil && item.Status == "cancelled") {
+			gone[entry.CalendarID+"/"+entry.EventID] = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("backend.Get(%s %s): %w", entry.Date, entry.Summary, err)
+		}
+		err = saved.add(entry.CalendarID, item)
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+	for len(undo) > 0 {
+		entry := undo[len(undo)-1]
+		var err error
+		if !gone[entry.CalendarID+"/"+entry.EventID] {
+			client := wfh.NewBackendClient(backend, entry.CalendarID, wfh.WithLocation(config.Location()))
+			err = client.Delete(entry.EventID)
+		}
+		if gone[entry.CalendarID+"/"+entry.EventID] || errors.Is(err, wfh.ErrNotFound) {
+			fmt.Printf("Skipping %s %s, already deleted\n", entry.Date, entry.Summary)
+			history = history[:len(history)-1]
+			undo = undo[:len(undo)-1]
+			continue
+		}
+		if err != nil {
+			// what was deleted so far is forgotten, so a retry picks up here.
+			if serr := saveHistory(config.dir, history); serr != nil {
+				log.Printf("Unable to save %s: %v", historyFile, serr)
+			}
+			return fmt.Errorf("client.Delete(%s %s): %w", entry.Date, entry.Summary, err)
+		}
+		config.logEvent("deleted", &calendar.Event{
+			Id:      entry.EventID,
+			Summary: entry.Summary,
+			Start:   &calendar.EventDateTime{Date: entry.Date},
+		})
+		fmt.Printf("Deleted %s %s\n", entry.Date, entry.Summary)
+		history = history[:len(history)-1]
+		undo = undo[:len(undo)-1]
+	}
+	err = saveHistory(config.dir, history)
+	if err != nil {
+		return fmt.Errorf("saveHistory: %w", err)
+	}
+	return nil
+}
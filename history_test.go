@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memoryBackend is a calendar in memory, for the functions that take a wfh.Backend.
+type memoryBackend struct {
+	wfh.Backend
+	events  map[string]*calendar.Event
+	deleted []string
+}
+
+func (m *memoryBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	event, ok := m.events[eventID]
+	if !ok {
+		return nil, wfh.ErrNotFound
+	}
+	return event, nil
+}
+
+func (m *memoryBackend) Delete(calendarID, eventID string) error {
+	event, ok := m.events[eventID]
+	if !ok || event.Status == "cancelled" {
+		return fmt.Errorf("Delete(%s): %w", eventID, wfh.ErrNotFound)
+	}
+	delete(m.events, eventID)
+	m.deleted = append(m.deleted, eventID)
+	return nil
+}
+
+func TestUndoLastSkipsDeleted(t *testing.T) {
+	tests := []struct {
+		name   string
+		middle *calendar.Event // the middle event in the calendar, nil if it's gone
+	}{
+		{"gone", nil},
+		{"cancelled", &calendar.Event{Id: "b", Summary: "WFH", Status: "cancelled"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{dir: t.TempDir(), location: time.UTC}
+			backend := &memoryBackend{events: map[string]*calendar.Event{
+				"a": {Id: "a", Summary: "WFH"},
+				"c": {Id: "c", Summary: "WFH"},
+			}}
+			if tt.middle != nil {
+				backend.events["b"] = tt.middle
+			}
+			history := []historyEntry{
+				{CalendarID: "primary", EventID: "old", Date: "2024-05-31", Summary: "WFH"},
+				{CalendarID: "primary", EventID: "a", Date: "2024-06-03", Summary: "WFH"},
+				{CalendarID: "primary", EventID: "b", Date: "2024-06-04", Summary: "WFH"},
+				{CalendarID: "primary", EventID: "c", Date: "2024-06-05", Summary: "WFH"},
+			}
+			err := saveHistory(config.dir, history)
+			if err != nil {
+				t.Fatalf("saveHistory: %v", err)
+			}
+			err = undoLast(backend, config, options{undoLastN: 3, force: true})
+			if err != nil {
+				t.Fatalf("undoLast: %v", err)
+			}
+			if want := []string{"c", "a"}; !slices.Equal(backend.deleted, want) {
+				t.Errorf("deleted %v, want %v", backend.deleted, want)
+			}
+			left, err := loadHistory(config.dir)
+			if err != nil {
+				t.Fatalf("loadHistory: %v", err)
+			}
+			if len(left) != 1 || left[0].EventID != "old" {
+				t.Errorf("the history has %+v left, want only the old event", left)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// loadHolidays reads a CSV file of date,name lines, with dates as YYYY-MM-DD, into a
+// map from date to name. A header line is allowed. A relative path is taken to be in
+// the config directory.
+func loadHolidays(configPath, path string) (map[string]string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(configPath, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer f.Close() // nolint: errcheck
+	return parseHolidays(f)
+}
+
+func parseHolidays(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	// the name is optional.
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	holidays := make(map[string]string)
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return holidays, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv.Read: %w", err)
+		}
+		date := strings.TrimSpace(record[0])
+		if line == 1 && strings.EqualFold(date, "date") {
+			continue
+		}
+		if date == "" {
+			continue
+		}
+		_, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q isn't a YYYY-MM-DD date", line, date)
+		}
+		name := "holiday"
+		if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+			name = strings.TrimSpace(record[1])
+		}
+		holidays[date] = name
+	}
+}
+
+// holiday returns the name of the holiday on the day, if it's in holidays_file.
+func (c Config) holiday(day time.Time) (string, bool) {
+	name, ok := c.holidays[day.Format("2006-01-02")]
+	return name, ok
+}
+
+// skipHolidays leaves out the days in holidays_file, reporting each one skipped.
+func skipHolidays(config Config, days []time.Time) []time.Time {
+	var remaining []time.Time
+	for _, day := range days {
+		if name, ok := config.holiday(day); ok {
+			fmt.Printf("Skipping %s, %s\n", day.Format("2006-01-02"), name)
+			continue
+		}
+		remaining = append(remaining, day)
+	}
+	return remaining
+}
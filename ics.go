@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// icsEvent is the part of a VEVENT we care about.
+type icsEvent struct {
+	summary string
+	// days the event covers. All-day events may span several days, timed events are
+	// put on the day they start.
+	days []time.Time
+}
+
+// parseICS reads the VEVENTs from an iCalendar file. Times without a time zone are
+// taken to be in loc.
+func parseICS(r io.Reader, loc *time.Location) ([]icsEvent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []icsEvent
+	var inEvent bool
+	var summary, start, end string
+	for i, line := range lines {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent = true
+			summary, start, end = "", "", ""
+		case name == "END" && value == "VEVENT":
+			inEvent = false
+			if start == "" {
+				return nil, fmt.Errorf("line %d: VEVENT without DTSTART", i+1)
+			}
+			days, err := icsDays(start, end, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			events = append(events, icsEvent{summary: summary, days: days})
+		case !inEvent:
+		case name == "SUMMARY":
+			summary = unescapeICS(value)
+		case name == "DTSTART":
+			start = params + ":" + value
+		case name == "DTEND":
+			end = params + ":" + value
+		}
+	}
+	return events, nil
+}
+
+// unfoldICS joins continuation lines, which start with a space or a tab.
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Scan: %w", err)
+	}
+	return lines, nil
+}
+
+// splitICSLine splits "DTSTART;TZID=Europe/Oslo:20240604T090000" into the name,
+// the parameters and the value.
+func splitICSLine(line string) (name, params, value string) {
+	head, value, _ := strings.Cut(line, ":")
+	name, params, _ = strings.Cut(head, ";")
+	return strings.ToUpper(name), params, value
+}
+
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// icsDays returns the days covered by an event starting at start and ending at end,
+// both given as "params:value". end may be empty.
+func icsDays(start, end string, loc *time.Location) ([]time.Time, error) {
+	first, allDay, err := parseICSTime(start, loc)
+	if err != nil {
+		return nil, fmt.Errorf("DTSTART: %w", err)
+	}
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	if !allDay || end == "" {
+		return []time.Time{first}, nil
+	}
+	// DTEND of an all-day event is the day after the last one.
+	last, _, err := parseICSTime(end, loc)
+	if err != nil {
+		return nil, fmt.Errorf("DTEND: %w", err)
+	}
+	days := []time.Time{first}
+	for day := first.AddDate(0, 0, 1); day.Before(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME property, reporting whether it was a DATE.
+func parseICSTime(s string, loc *time.Location) (time.Time, bool, error) {
+	params, value, _ := strings.Cut(s, ":")
+	for _, param := range strings.Split(params, ";") {
+		key, val, _ := strings.Cut(param, "=")
+		switch strings.ToUpper(key) {
+		case "VALUE":
+			if strings.ToUpper(val) == "DATE" {
+				t, err := time.ParseInLocation("20060102", value, loc)
+				return t, true, err
+			}
+		case "TZID":
+			tz, err := time.LoadLocation(val)
+			if err != nil {
+				return time.Time{}, false, fmt.Errorf("time.LoadLocation(%s): %w", val, err)
+			}
+			loc = tz
+		}
+	}
+	switch {
+	case len(value) == len("20060102"):
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse("20060102T150405Z", value)
+		return t.In(loc), false, err
+	default:
+		t, err := time.ParseInLocation("20060102T150405", value, loc)
+		return t, false, err
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lastBooking is what was booked last, so -repeat-last can book it again.
+type lastBooking struct {
+	Date       string `json:"date"`
+	Message    string `json:"message"`
+	ColorID    int    `json:"color_id"`
+	CalendarID string `json:"calendar_id"`
+}
+
+const lastBookingFile = "last.json"
+
+func loadLastBooking(configPath string) (lastBooking, error) {
+	var last lastBooking
+	b, err := os.ReadFile(filepath.Join(configPath, lastBookingFile))
+	if os.IsNotExist(err) {
+		return last, fmt.Errorf("nothing booked yet")
+	}
+	if err != nil {
+		return last, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	err = json.Unmarshal(b, &last)
+	if err != nil {
+		return last, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return last, nil
+}
+
+func (last lastBooking) save(configPath string) error {
+	b, err := json.MarshalIndent(last, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(configPath, lastBookingFile), b, 0600)
+	if err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"io"
+	"strings"
+)
+
+// eventLister is where listings get their events from: the calendar, or the local cache.
+type eventLister interface {
+	List(r wfh.Range, opts wfh.ListOptions) ([]*calendar.Event, error)
+}
+
+// Event is a calendar event, as listings show it.
+type Event struct {
+	ID         string
+	Date       string
+	Summary    string
+	AllDay     bool
+	Start      string
+	End        string
+	Creator    string
+	ColorID    string
+	Visibility string
+	// Location is where the event takes place, if it says.
+	Location string
+	// MeetLink is the video call of the event, if it has one.
+	MeetLink string
+	// WFH is set for WFH bookings.
+	WFH bool
+}
+
+// newEvent converts an event from the API.
+func newEvent(item *calendar.Event) Event {
+	event := Event{
+		ID:         item.Id,
+		Date:       wfh.EventDate(item),
+		Summary:    item.Summary,
+		AllDay:     item.Start == nil || item.Start.DateTime == "",
+		ColorID:    item.ColorId,
+		Visibility: item.Visibility,
+		Location:   item.Location,
+	}
+	if !event.AllDay {
+		event.Start, event.End = item.Start.DateTime, item.End.DateTime
+	}
+	if item.Creator != nil {
+		event.Creator = item.Creator.Email
+	}
+	event.MeetLink = meetLink(item)
+	// who it was booked for says more than a shared account that created it.
+	if item.ExtendedProperties != nil && item.ExtendedProperties.Private[wfh.BookerKey] != "" {
+		event.Creator = item.ExtendedProperties.Private[wfh.BookerKey]
+	}
+	if event.Visibility == "" {
+		// the API leaves it out for the default.
+		event.Visibility = "default"
+	}
+	return event
+}
+
+// meetLink returns the link to join the event's video call. HangoutLink is only set for
+// Google Meet, other conferences have a video entry point.
+func meetLink(item *calendar.Event) string {
+	if item.HangoutLink != "" {
+		return item.HangoutLink
+	}
+	if item.ConferenceData != nil {
+		for _, entry := range item.ConferenceData.EntryPoints {
+			if entry.EntryPointType == "video" {
+				return entry.Uri
+			}
+		}
+	}
+	return ""
+}
+
+// listItems returns the events in the requested range as the API has them.
+func listItems(lister eventLister, config Config, opts options) ([]*calendar.Event, error) {
+	items, err := lister.List(wfh.Range{From: opts.from, To: opts.to}, wfh.ListOptions{
+		Limit:   opts.limit,
+		OrderBy: opts.sort,
+		Reverse: opts.reverse,
+		Text:    opts.query,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !opts.noDeclined {
+		return items, nil
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if !declined(item, config.User) {
+			kept = append(kept, item)
+		}
+	}
+	return kept, nil
+}
+
+// listEvents returns the events in the requested range.
+func listEvents(lister eventLister, config Config, opts options) ([]Event, error) {
+	items, err := listItems(lister, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(items))
+	for _, item := range items {
+		event := newEvent(item)
+		event.WFH = wfh.IsWFH(item, config.DefaultMessage, config.MatchMode)
+		if opts.sanitize {
+			event = event.sanitized()
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// printRaw writes the events as JSON, with every field the API returned, for
+// troubleshooting.
+func printRaw(w io.Writer, items []*calendar.Event) error {
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// declined reports whether you declined the event: the attendee that is the calendar's
+// owner, or has user as email address, said no.
+func declined(item *calendar.Event, user string) bool {
+	for _, attendee := range item.Attendees {
+		if attendee.Self || (user != "" && strings.EqualFold(attendee.Email, user)) {
+			return attendee.ResponseStatus == "declined"
+		}
+	}
+	return false
+}
+
+// sanitizedSummary replaces the summary of events that aren't WFH bookings in sanitized
+// listings.
+const sanitizedSummary = "(busy)"
+
+// sanitized returns the event with what could identify people removed, for listings
+// shown to others: the creator is cut down to initials, and only WFH bookings keep
+// their summary.
+func (event Event) sanitized() Event {
+	event.Creator = initials(event.Creator)
+	if !event.WFH {
+		event.Summary = sanitizedSummary
+	}
+	return event
+}
+
+// initials returns the initials of the name in an email address, like JD for
+// jane.doe@example.com.
+func initials(email string) string {
+	name, _, _ := strings.Cut(email, "@")
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return strings.ContainsRune("._-+ ", r) }) {
+		b.WriteString(strings.ToUpper(string([]rune(part)[0])))
+	}
+	return b.String()
+}
+
+// printEvents writes the events as text, one per line.
+func printEvents(w io.Writer, events []Event, config Config, verbose bool) {
+	if len(events) == 0 {
+		_, _ = fmt.Fprintln(w, "No events found.")
+		return
+	}
+	_, _ = fmt.Fprintln(w, "Events:")
+	for _, event := range events {
+		timeString := "(all day)"
+		if !event.AllDay {
+			timeString = fmt.Sprintf("(%v --> %v)", event.Start, event.End)
+		}
+		_, _ = fmt.Fprintf(w, "%v %s [%s]", event.Summary, timeString, shortEmail(event.Creator))
+		if verbose {
+			_, _ = fmt.Fprintf(w, " id=%s color=%s visibility=%s", event.ID, colorString(config, event.ColorID), event.Visibility)
+			if event.Location != "" {
+				_, _ = fmt.Fprintf(w, " location=%q", event.Location)
+			}
+			if event.MeetLink != "" {
+				_, _ = fmt.Fprintf(w, " meet=%s", event.MeetLink)
+			}
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+}
+
+// printMarkdown writes the events as a Markdown table, for pasting into docs and chats.
+func printMarkdown(w io.Writer, events []Event, calendarName string) {
+	_, _ = fmt.Fprintln(w, "| Date | Summary | Calendar |")
+	_, _ = fmt.Fprintln(w, "| --- | --- | --- |")
+	for _, event := range events {
+		_, _ = fmt.Fprintf(w, "| %s | %s | %s |\n", event.Date, markdownCell(event.Summary), markdownCell(calendarName))
+	}
+}
+
+// markdownCell escapes what would break out of a table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// calendarName returns the name of the calendar, if it's in the calendar cache, or its ID.
+func calendarName(config Config, calendarID string) string {
+	if cached, ok := loadCalendarCache(config.dir)[calendarID]; ok && cached.Summary != "" {
+		return cached.Summary
+	}
+	return calendarID
+}
+
+// colorString returns the color ID, followed by its name if one is configured.
+func colorString(config Config, colorID string) string {
+	if colorID == "" {
+		// the event uses the calendar's color.
+		return "default"
+	}
+	if name, ok := config.ColorNames[colorID]; ok {
+		return fmt.Sprintf("%s (%s)", colorID, name)
+	}
+	return colorID
+}
+
+func shortEmail(email string) string {
+	atIndex := len(email)
+	for i, c := range email {
+		if c == '@' {
+			atIndex = i
+			break
+		}
+	}
+	return email[:atIndex]
+
+}
@@ -1,305 +1,1511 @@
 package main
 
 import (
-	"context"
-	_ "embed"
+	"bufio"
+	"bytes"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"golang.org/x/oauth2"
+	"github.com/perbu/wfh/pkg/wfh"
 	"golang.org/x/oauth2/google"
 	calendar "google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
+	"io"
 	"log"
-	"math/rand"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
-//go:embed credentials.json
-var googleCredentials []byte
+func main() {
+	// the config is loaded before the flags are parsed, as it holds defaults for them.
+	// Errors are reported after parsing, so -help works without a config.
+	configPath, pathErr := getConfigPath()
+	config, configErr := Config{}, pathErr
+	if pathErr == nil {
+		config, configErr = getConfig(configPath)
+	}
+	opts, err := parseArgs(config.Defaults)
+	if err != nil {
+		fmt.Printf("while parsing arguments and flags: %v\n", err)
+		exit(1)
+	}
+	if pathErr != nil {
+		fatalf("Unable to find config directory: %v", pathErr)
+	}
+	// Check if gconfig directory exists, if not, create it.
+	fi, err := os.Stat(configPath)
+	if os.IsNotExist(err) {
+		err := os.Mkdir(configPath, 0700)
+		if err != nil {
+			fatalf("Unable to create config directory: %v", err)
+		}
+	} else if err == nil && fi.Mode().Perm()&0077 != 0 {
+		// older versions created it 0777, and it holds the tokens.
+		err := os.Chmod(configPath, 0700)
+		if err != nil {
+			log.Printf("Unable to make %s private, others may read your tokens: %v", configPath, err)
+		}
+	}
+	tokenPath := filepath.Join(configPath, "token.json")
 
-type Config struct {
-	CalendarID     string `json:"calendar_id"`
-	DefaultMessage string `json:"default_message"`
-	User           string `json:"user"`
-}
+	if configErr != nil {
+		fatalf("Unable to load config file: %v", configErr)
+	}
+	err = config.validate()
+	if err != nil {
+		fatalf("Invalid config file: %v", err)
+	}
+	// dates are resolved in the time zone of the calendar booked in.
+	config = config.forCalendar(opts.calendarArg)
+	err = opts.resolve(config)
+	if err != nil {
+		fmt.Printf("while parsing arguments and flags: %v\n", err)
+		exit(1)
+	}
+	if opts.printConfig {
+		err = printConfig(os.Stdout, config, opts, configPath)
+		if err != nil {
+			fatalf("Unable to print config: %v", err)
+		}
+		exit(0)
+	}
+	if opts.tokenInfo {
+		err = printTokenInfo(os.Stdout, tokenFile(config, configPath))
+		if err != nil {
+			fatalf("Unable to read token: %v", err)
+		}
+		exit(0)
+	}
+	if opts.calendarID == "" && (opts.offline || opts.dryRun) {
+		// the calendar picker needs to talk to Google.
+		fatalf("No calendar, set calendar_id in the config or use -calendar")
+	}
+	if opts.dryRun && (opts.isBooking() || opts.office) {
+		// no calendar service, dry runs of a booking must work without authentication.
+		// Other actions need to read the calendar to say what they would do.
+		err = dryRun(wfh.NewClient(nil, opts.calendarID, config.clientOptions(config.Location())...), config, opts)
+		if err != nil {
+			fatalf("Dry run failed: %v", err)
+		}
+		exit(0)
+	}
+	if opts.validateCred {
+		problems := checkCredentials(googleCredentials)
+		for _, problem := range problems {
+			fmt.Printf("Problem: %s\n", problem)
+		}
+		if len(problems) > 0 {
+			exit(1)
+		}
+		fmt.Println("The embedded credentials look fine.")
+		exit(0)
+	}
+	if opts.colorLegend {
+		printColorLegend(os.Stdout, config, isTerminal(os.Stdout))
+		exit(0)
+	}
+	if opts.previewLink {
+		// the link is opened by the user, no need to authenticate.
+		if id, ok := loadCalendarCache(configPath).lookup(opts.calendarID); ok {
+			opts.calendarID = id
+		}
+		err = printPreviewLinks(config, opts)
+		if err != nil {
+			fatalf("Unable to make preview link: %v", err)
+		}
+		exit(0)
+	}
+	if opts.revoke {
+		if config.Provider == providerMicrosoft {
+			fatalf("-revoke only works with Google. Delete %s and remove wfh under https://myapps.microsoft.com instead.",
+				filepath.Join(configPath, "ms-token.json"))
+		}
+		err = revokeToken(tokenPath)
+		if err != nil {
+			fatalf("Unable to revoke token: %v", err)
+		}
+		fmt.Println("Token revoked and removed.")
+		exit(0)
+	}
 
-func getConfigPath() string {
-	homeDir, err := os.UserHomeDir()
+	if opts.offline {
+		// no network, so calendar names can only be resolved from what's cached.
+		if id, ok := loadCalendarCache(configPath).lookup(opts.calendarID); ok {
+			opts.calendarID = id
+		}
+		cache, err := loadEventCache(configPath)
+		if err != nil {
+			fatalf("Unable to load the event cache: %v", err)
+		}
+		runListing(cache.lister(opts.calendarID), config, opts)
+		exit(0)
+	}
+
+	var backend wfh.Backend
+	if config.Provider == providerMicrosoft {
+		// the Microsoft token is kept apart, so switching provider doesn't need a new Google login.
+		httpClient := getHTTPClient(microsoftConfig(config), filepath.Join(configPath, "ms-token.json"), opts.authMode, opts.callbackPorts, opts.forceRefresh)
+		backend = wfh.MicrosoftGraph(httpClient)
+	} else if keyFile := serviceAccountFile(config); keyFile != "" {
+		calService, err := getServiceAccountClient(keyFile, config.User)
+		if err != nil {
+			fatalf("Unable to authenticate with service account: %v", err)
+		}
+		backend = wfh.Google(calService)
+	} else {
+		if _, err := os.Stat(tokenPath); err != nil {
+			// about to log in, a broken client fails only after the browser round trip.
+			for _, problem := range checkCredentials(googleCredentials) {
+				log.Printf("Warning: embedded credentials: %s", problem)
+			}
+		}
+		gconfig, err := google.ConfigFromJSON(googleCredentials, scopes...)
+		if err != nil {
+			fatalf("Unable to parse client secret file to gconfig: %v", err)
+		}
+		backend = wfh.Google(getClient(gconfig, tokenPath, opts.authMode, opts.callbackPorts, opts.forceRefresh))
+	}
+	if opts.metrics != "" {
+		backend = metricsBackend{Backend: backend, m: newMetrics(opts.metrics)}
+	}
+	if opts.restoreFile != "" {
+		err = restoreBackup(backend, config, opts)
+		if err != nil {
+			fatalf("Unable to restore %s: %v", opts.restoreFile, err)
+		}
+		exit(0)
+	}
+	if opts.undoLastN > 0 {
+		err = undoLast(backend, config, opts)
+		if err != nil {
+			fatalf("Unable to undo: %v", err)
+		}
+		exit(0)
+	}
+	if opts.calStatus {
+		err = allCalendarsStatus(backend, config, opts)
+		if err != nil {
+			fatalf("Unable to get calendar status: %v", err)
+		}
+		exit(0)
+	}
+	if opts.calendarID == "" {
+		opts.calendarID, err = pickCalendar(backend, configPath)
+		if err != nil {
+			fatalf("No calendar: %v", err)
+		}
+	}
+	opts.calendarID, err = resolveCalendar(backend, configPath, opts.calendarID)
+	if err != nil {
+		fatalf("Unable to find calendar: %v", err)
+	}
+	client := wfh.NewBackendClient(backend, opts.calendarID, config.clientOptions(config.Location())...)
+	checkTimeZone(client, config, configPath)
+	if runListing(client, config, opts) {
+		exit(0)
+	}
+	if opts.sync {
+		err = syncCache(client, config, configPath, opts)
+		if err != nil {
+			fatalf("Unable to sync the event cache: %v", err)
+		}
+		exit(0)
+	}
+	if opts.serve != "" {
+		err = serve(opts.serve, client, config, opts)
+		fatalf("Unable to serve: %v", err)
+	}
+	if opts.plan {
+		err = plan(client, config, opts)
+		if err != nil {
+			fatalf("Unable to plan: %v", err)
+		}
+		exit(0)
+	}
+	if opts.deleteID != "" {
+		err = client.Delete(opts.deleteID)
+		if err != nil {
+			fatalf("Unable to delete event: %v", err)
+		}
+		config.logEvent("deleted", &calendar.Event{Id: opts.deleteID})
+		fmt.Printf("Deleted %s\n", opts.deleteID)
+		exit(0)
+	}
+	if opts.clearToday {
+		err = clearToday(client, config, opts)
+		if err != nil {
+			fatalf("Unable to clear today: %v", err)
+		}
+		exit(0)
+	}
+	if opts.office {
+		err = markOffice(client, config, opts)
+		if err != nil {
+			fatalf("Unable to mark office day: %v", err)
+		}
+		exit(0)
+	}
+	if opts.backfill {
+		err = backfill(client, config, opts)
+		if err != nil {
+			fatalf("Unable to backfill: %v", err)
+		}
+		exit(0)
+	}
+	if opts.appendNote != "" {
+		err = appendNote(client, config, opts)
+		if err != nil {
+			fatalf("Unable to append note: %v", err)
+		}
+		exit(0)
+	}
+	if opts.batchFile != "" {
+		err = runBatch(client, config, opts)
+		if err != nil {
+			fatalf("Unable to run batch %s: %v", opts.batchFile, err)
+		}
+		exit(0)
+	}
+	if opts.taskPattern != nil {
+		if config.Provider == providerMicrosoft {
+			fatalf("-from-google-tasks only works with Google calendars")
+		}
+		service, err := tasksService(configPath, opts)
+		if err != nil {
+			fatalf("Unable to log in to Google Tasks: %v", err)
+		}
+		err = bookFromTasks(client, service, config, opts)
+		if err != nil {
+			fatalf("Unable to book from Google Tasks: %v", err)
+		}
+		exit(0)
+	}
+	if opts.importFile != "" {
+		err = importICS(client, config, opts)
+		if err != nil {
+			fatalf("Unable to import %s: %v", opts.importFile, err)
+		}
+		exit(0)
+	}
+	if opts.migrate != "" {
+		err = migrateSummary(client, opts)
+		if err != nil {
+			fatalf("Unable to migrate events: %v", err)
+		}
+		exit(0)
+	}
+	if opts.archive {
+		err = archive(client, backend, config, opts, configPath)
+		if err != nil {
+			fatalf("Unable to archive events: %v", err)
+		}
+		exit(0)
+	}
+	if opts.recolor {
+		err = recolor(client, config, opts)
+		if err != nil {
+			fatalf("Unable to recolor events: %v", err)
+		}
+		exit(0)
+	}
+	if opts.update {
+		err = updateEvent(client, config, opts)
+		if err != nil {
+			fatalf("Unable to update event: %v", err)
+		}
+		exit(0)
+	}
+	days := bookingDays(config, opts)
+	if len(days) > 1 && len(config.SkipSummaries) > 0 {
+		days, err = skipDaysOff(client, config, days)
+		if err != nil {
+			fatalf("Unable to check for days off: %v", err)
+		}
+	}
+	if len(days) > 0 && len(config.ConflictKeywords) > 0 && !opts.force {
+		conflicts, err := findConflicts(client, config, days)
+		if err != nil {
+			fatalf("Unable to check for conflicting events: %v", err)
+		}
+		if len(conflicts) > 0 {
+			fmt.Println("These events conflict with working from home:")
+			for _, conflict := range conflicts {
+				fmt.Printf("  %s\n", conflict)
+			}
+			fatalf("Nothing booked, use -force to book anyway")
+		}
+	}
+	mirrors, err := mirrorCalendars(backend, config, configPath, opts.calendarID)
 	if err != nil {
-		log.Fatalf("Unable to find user home directory: %v", err)
+		fatalf("Unable to find mirror calendar: %v", err)
+	}
+	if opts.explain {
+		fmt.Println(explainBooking(config, opts, days, mirrors))
+		if isTerminal(os.Stdin) && !opts.force && !confirm("Proceed?") {
+			fatalf("Nothing booked, aborted by user")
+		}
+	}
+	mirrorFailures := 0
+	var failed []string
+	for _, day := range days {
+		n, err := bookDay(client, backend, config, opts, configPath, mirrors, day)
+		mirrorFailures += n
+		if err != nil {
+			if opts.failFast || len(days) == 1 {
+				fatalf("Unable to create event. %v\n", err)
+			}
+			log.Printf("Unable to book %s: %v", day.Format("2006-01-02"), err)
+			failed = append(failed, day.Format("2006-01-02"))
+		}
+	}
+	if len(days) > 1 {
+		fmt.Printf("Booked %d of %d days\n", len(days)-len(failed), len(days))
+	}
+	if len(failed) > 0 {
+		fatalf("Unable to book %s", strings.Join(failed, ", "))
+	}
+	if mirrorFailures > 0 {
+		fatalf("%d mirror booking(s) failed, the bookings in %s were made", mirrorFailures, opts.calendarID)
 	}
-	return filepath.Join(homeDir, ".wfh")
+	exit(0)
 }
 
-func getClient(config *oauth2.Config, tokenPath string) *calendar.Service {
-	tok, err := tokenFromFile(tokenPath)
+// bookDay books one day, and mirrors it. A failed mirror doesn't fail the day, the
+// booking in the main calendar stands, so the number of failed mirrors is returned.
+func bookDay(client *wfh.Client, backend wfh.Backend, config Config, opts options, configPath string, mirrors []string, day time.Time) (int, error) {
+	bookOpts := bookOptions(opts)
+	bookOpts.ColorID = config.dayColor(opts, day)
+	bookOpts.Start, bookOpts.End = opts.eventTimes(day)
+	bookOpts, err := withDescription(config, bookOpts, day)
 	if err != nil {
-		tok = getTokenFromWeb(config, tokenPath)
+		return 0, fmt.Errorf("withDescription: %w", err)
 	}
-	if tok != nil {
-		if len(tok.RefreshToken) == 0 {
-			log.Println("No refresh token found, please delete token.json, revoke the token and try again.")
-		}
+	event, err := client.Book(day, bookOpts)
+	if err != nil {
+		return 0, fmt.Errorf("client.Book: %w", err)
 	}
-	client := config.Client(context.Background(), tok)
-	srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	afterBooking(config, opts, event)
+	fmt.Printf("Event created: %s\nLink %s\n", event.Summary, event.HtmlLink)
+	// the color actually used, -repeat-last shouldn't pick another random one.
+	colorID, _ := strconv.Atoi(event.ColorId)
+	mirrorFailures := 0
+	if len(mirrors) > 0 {
+		fmt.Printf("Booked in %s: %s\n", opts.calendarID, event.Id)
+		// the same color everywhere.
+		bookOpts.ColorID = colorID
+		mirrorFailures = mirrorBooking(backend, config, mirrors, day, bookOpts)
+	}
+	last := lastBooking{Date: wfh.EventDate(event), Message: opts.message, ColorID: colorID, CalendarID: opts.calendarID}
+	err = last.save(configPath)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		log.Printf("Unable to remember the booking for -repeat-last: %v", err)
 	}
-	return srv
+	return mirrorFailures, nil
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config, tokenPath string) *oauth2.Token {
-	// make a state token to prevent CSRF attacks:
-	state := randomString(16)
-	// We'll use a channel to block until we get the authorization code
-	codeCh := make(chan string)
+// mirrorCalendars resolves mirror_calendars to calendar IDs, leaving out the calendar
+// booked in.
+func mirrorCalendars(backend wfh.Backend, config Config, configPath, calendarID string) ([]string, error) {
+	var ids []string
+	for _, name := range config.MirrorCalendars {
+		id, err := resolveCalendar(backend, configPath, config.calendarID(name))
+		if err != nil {
+			return nil, fmt.Errorf("resolveCalendar(%s): %w", name, err)
+		}
+		if id != calendarID && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
 
-	// Start a local server to listen on a specified port
-	srv := &http.Server{Addr: ":8066"}
+// mirrorBooking books the day in each of the mirror calendars too. The booking in the
+// main calendar stands when a mirror fails, so each result is reported, and the number
+// of failures returned.
+func mirrorBooking(backend wfh.Backend, config Config, mirrors []string, day time.Time, bookOpts wfh.BookOptions) int {
+	failed := 0
+	for _, id := range mirrors {
+		client := wfh.NewBackendClient(backend, id, config.clientOptions(config.calendarLocation(id))...)
+		event, err := client.Book(day, bookOpts)
+		if err != nil {
+			log.Printf("Unable to mirror %s to %s: %v", day.Format("2006-01-02"), id, err)
+			failed++
+			continue
+		}
+		config.logEvent("booked", event)
+		config.addHistory(id, event)
+		fmt.Printf("Mirrored to %s: %s\n", id, event.Id)
+	}
+	return failed
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		recvState := r.URL.Query().Get("state")
-		if recvState != state {
-			_, _ = fmt.Fprintf(w, "Invalid state: %s\n", recvState) // nolint: errcheck
-			return
+// bookingDays returns the days to book. A single date is booked as given, a range
+// of dates only on its working days that aren't holidays.
+func bookingDays(config Config, opts options) []time.Time {
+	if opts.from.Equal(opts.to) {
+		return []time.Time{opts.date}
+	}
+	var days []time.Time
+	for day := opts.from; !day.After(opts.to); day = day.AddDate(0, 0, 1) {
+		if config.isWorkingDay(day.Weekday()) {
+			days = append(days, day)
 		}
-		_, _ = fmt.Fprintln(w, "Received authentication code. You can close this page now.") // nolint: errcheck
-		codeCh <- code                                                                       // Send code to our waiting getTokenFromWeb function
-	})
+	}
+	return skipHolidays(config, days)
+}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe(): %v", err)
+// skipDaysOff leaves out the days covered by an event whose summary contains one of
+// skip_summaries, ignoring case, like a vacation. The skipped days are reported.
+func skipDaysOff(client *wfh.Client, config Config, days []time.Time) ([]time.Time, error) {
+	items, err := client.List(wfh.Range{From: days[0], To: days[len(days)-1]}, wfh.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("client.List: %w", err)
+	}
+	off := make(map[string]string)
+	for _, item := range items {
+		for _, skip := range config.SkipSummaries {
+			if !strings.Contains(strings.ToLower(item.Summary), strings.ToLower(skip)) {
+				continue
+			}
+			for _, date := range coveredDays(item) {
+				off[date] = item.Summary
+			}
 		}
-	}()
+	}
+	var remaining []time.Time
+	for _, day := range days {
+		date := day.Format("2006-01-02")
+		if summary, ok := off[date]; ok {
+			fmt.Printf("Skipping %s, %q\n", date, summary)
+			continue
+		}
+		remaining = append(remaining, day)
+	}
+	return remaining, nil
+}
 
-	// Here, set your redirect URL to `http://localhost:8066/`
-	// This should match one of the URIs you set in your Google Developer Console
-	authURL := config.AuthCodeURL(state,
-		oauth2.AccessTypeOffline,
-		oauth2.SetAuthURLParam("redirect_uri", "http://localhost:8066/"),
-	)
-	fmt.Printf("Go to the following link in your browser:\n%v\n", authURL)
+// findConflicts returns the events on the days mentioning one of conflict_keywords,
+// ignoring case, like an onsite meeting, as date and summary.
+func findConflicts(client *wfh.Client, config Config, days []time.Time) ([]string, error) {
+	items, err := client.List(wfh.Range{From: days[0], To: days[len(days)-1]}, wfh.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("client.List: %w", err)
+	}
+	booking := make(map[string]bool)
+	for _, day := range days {
+		booking[day.Format("2006-01-02")] = true
+	}
+	var conflicts []string
+	for _, item := range items {
+		if !config.conflicting(item) {
+			continue
+		}
+		for _, date := range coveredDays(item) {
+			if booking[date] {
+				conflicts = append(conflicts, fmt.Sprintf("%s %q", date, item.Summary))
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// conflicting reports whether the event isn't a WFH event and mentions one of
+// conflict_keywords, ignoring case.
+func (c Config) conflicting(item *calendar.Event) bool {
+	if wfh.IsWFH(item, c.DefaultMessage, c.MatchMode) {
+		return false
+	}
+	for _, keyword := range c.ConflictKeywords {
+		if wfh.MatchesText(item, keyword) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Block until we receive the code
-	authCode := <-codeCh
-	// Shutdown the server
+// coveredDays returns the days an event covers, as YYYY-MM-DD. The end of an all-day
+// event is exclusive, but wfh books them ending on the same day.
+func coveredDays(item *calendar.Event) []string {
+	start := wfh.EventDate(item)
+	end := start
+	if item.End != nil && item.End.Date != "" {
+		end = item.End.Date
+	} else if item.End != nil {
+		if t, err := time.Parse(time.RFC3339, item.End.DateTime); err == nil {
+			end = t.Format("2006-01-02")
+		}
+	}
+	days := []string{start}
+	day, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return days
+	}
+	for day = day.AddDate(0, 0, 1); day.Format("2006-01-02") < end; day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel() // Cancel context when done to release resources
+// runListing runs the actions that only read events. It reports whether there was one.
+// The listing goes to -out if given, status messages always go to stdout.
+func runListing(lister eventLister, config Config, opts options) bool {
+	if !opts.list && !opts.isMonth && !opts.weekdays && !opts.conflicts {
+		return false
+	}
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if opts.out != "" {
+		var err error
+		f, err = os.Create(opts.out)
+		if err != nil {
+			fatalf("Unable to create output file: %v", err)
+		}
+		w = f
+	}
+	var paged *bytes.Buffer
+	if usePager(opts) {
+		paged = &bytes.Buffer{}
+		w = paged
+	}
+	switch {
+	case opts.list && opts.raw:
+		items, err := listItems(lister, config, opts)
+		if err != nil {
+			fatalf("Unable to retrieve the user's events: %v", err)
+		}
+		err = printRaw(w, items)
+		if err != nil {
+			fatalf("Unable to write events: %v", err)
+		}
+	case opts.list:
+		// just list the events and then exit.
+		if opts.export == "" {
+			fmt.Printf("listing events for %s to %s\n", opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02"))
+		}
+		events, err := listEvents(lister, config, opts)
+		if err != nil {
+			fatalf("Unable to retrieve the user's events: %v", err)
+		}
+		if opts.export == "md" {
+			printMarkdown(w, events, calendarName(config, opts.calendarID))
+		} else {
+			printEvents(w, events, config, opts.verbose)
+		}
+	case opts.isMonth:
+		err := countMonth(w, lister, config, opts)
+		if err != nil {
+			fatalf("Unable to count WFH days: %v", err)
+		}
+	case opts.weekdays:
+		err := weekdaySummary(w, lister, config, opts)
+		if err != nil {
+			fatalf("Unable to summarize weekdays: %v", err)
+		}
+	case opts.conflicts:
+		err := conflictReport(w, lister, config, opts)
+		if err != nil {
+			fatalf("Unable to report conflicts: %v", err)
+		}
+	}
+	if f != nil {
+		// the data is only on disk once the file is closed.
+		err := f.Close()
+		if err != nil {
+			fatalf("Unable to write output file: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", opts.out)
+	}
+	if paged != nil {
+		page(paged.Bytes())
+	}
+	return true
+}
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("HTTP server Shutdown: %v", err)
+// markOffice deletes any WFH event on the given date and books an office marker instead.
+// If the office marker can't be created, the deleted events are put back.
+func markOffice(client *wfh.Client, config Config, opts options) error {
+	existing, err := client.FindWFH(wfh.Day(opts.date), config.DefaultMessage)
+	if err != nil {
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	if len(existing) > 0 && !opts.force {
+		fmt.Printf("The following WFH events on %s will be deleted:\n", opts.date.Format("2006-01-02"))
+		for _, item := range existing {
+			fmt.Printf("  %s\n", item.Summary)
+		}
+		if !confirm("Proceed?") {
+			return fmt.Errorf("aborted by user")
+		}
+	}
+	var deleted []*calendar.Event
+	for _, item := range existing {
+		err := client.Delete(item.Id)
+		if err != nil {
+			restoreEvents(client, deleted)
+			return fmt.Errorf("client.Delete: %w", err)
+		}
+		deleted = append(deleted, item)
 	}
-	tok, err := config.Exchange(context.TODO(), authCode,
-		oauth2.SetAuthURLParam("redirect_uri", "http://localhost:8066/"))
+	for _, item := range deleted {
+		config.logEvent("deleted", item)
+	}
+	bookOpts := bookOptions(opts)
+	bookOpts.Marker = wfh.MarkerOffice
+	event, err := client.Book(opts.date, bookOpts)
+	if err != nil {
+		restoreEvents(client, deleted)
+		return fmt.Errorf("client.Book: %w", err)
+	}
+	config.logEvent("booked", event)
+	fmt.Printf("Removed %d WFH event(s)\nEvent created: %s\nLink %s\n", len(deleted), event.Summary, event.HtmlLink)
+	return nil
+}
+
+// clearToday deletes today's WFH events, after asking unless -force is given.
+func clearToday(client *wfh.Client, config Config, opts options) error {
+	existing, err := client.FindWFH(wfh.Day(opts.date), config.DefaultMessage)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		return fmt.Errorf("client.FindWFH: %w", err)
 	}
-	err = saveToken(tokenPath, tok)
+	if len(existing) == 0 {
+		fmt.Println("No WFH events today.")
+		return nil
+	}
+	if !opts.force {
+		fmt.Println("The following WFH events today will be deleted:")
+		for _, item := range existing {
+			fmt.Printf("  %s\n", item.Summary)
+		}
+		if !confirm("Proceed?") {
+			return fmt.Errorf("aborted by user")
+		}
+	}
+	err = newBackup(config).add(client.CalendarID(), existing...)
 	if err != nil {
-		log.Fatalf("Unable to save token: %v", err)
+		return fmt.Errorf("backup: %w", err)
+	}
+	for _, item := range existing {
+		err := client.Delete(item.Id)
+		if err != nil {
+			return fmt.Errorf("client.Delete: %w", err)
+		}
+		config.logEvent("deleted", item)
 	}
+	fmt.Printf("Removed %d WFH event(s)\n", len(existing))
+	return nil
+}
+
+// bookOptions returns the options for booking the event described by the command line.
+func bookOptions(opts options) wfh.BookOptions {
+	bookOpts := wfh.BookOptions{
+		Message:      opts.message,
+		ColorID:      opts.color,
+		Description:  opts.description,
+		Location:     opts.location,
+		Transparency: opts.transparency,
+		Booker:       opts.booker,
+		Properties:   opts.properties,
+		Recurrence:   opts.recurrence,
+	}
+	if opts.attach != "" {
+		bookOpts.Attachments = []*calendar.EventAttachment{{FileUrl: opts.attach, Title: opts.attachTitle}}
+	}
+	for _, r := range opts.reminders {
+		bookOpts.Reminders = append(bookOpts.Reminders, &calendar.EventReminder{
+			Method:  r.Method,
+			Minutes: r.Minutes,
+			// a reminder at the start of the event is 0 minutes, which would otherwise be left out.
+			ForceSendFields: []string{"Minutes"},
+		})
+	}
+	return bookOpts
+}
 
-	return tok
+// descriptionData is what description_template can use.
+type descriptionData struct {
+	Date    string // YYYY-MM-DD
+	Weekday string
+	Week    int // ISO 8601 week number
+	Year    int
+	Message string
+	User    string
 }
 
-// randomString returns a random string of the specified length, using A-Z, a-z, 0-9
-func randomString(i int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, i)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// withDescription fills in the description from description_template, unless one was given.
+func withDescription(config Config, bookOpts wfh.BookOptions, day time.Time) (wfh.BookOptions, error) {
+	if bookOpts.Description != "" || config.descriptionTemplate == nil {
+		return bookOpts, nil
 	}
-	return string(b)
+	year, week := day.ISOWeek()
+	data := descriptionData{
+		Date:    day.Format("2006-01-02"),
+		Weekday: day.Weekday().String(),
+		Week:    week,
+		Year:    year,
+		Message: bookOpts.Message,
+		User:    config.User,
+	}
+	var b strings.Builder
+	err := config.descriptionTemplate.Execute(&b, data)
+	if err != nil {
+		return bookOpts, fmt.Errorf("template.Execute: %w", err)
+	}
+	bookOpts.Description = b.String()
+	return bookOpts, nil
+}
+
+// updateEvent patches the day's WFH event with the fields given on the command line.
+// With -dry-run it prints what would change instead.
+func updateEvent(client *wfh.Client, config Config, opts options) error {
+	patch := &calendar.Event{Summary: opts.message, Description: opts.description}
+	if opts.color != 0 {
+		patch.ColorId = strconv.Itoa(opts.color)
+	}
+	if patch.Summary == "" && patch.Description == "" && patch.ColorId == "" {
+		return fmt.Errorf("nothing to update, use -message, -color or -description")
+	}
+	existing, err := client.FindWFH(wfh.Day(opts.date), config.DefaultMessage)
+	if err != nil {
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("no WFH event found on %s", opts.date.Format("2006-01-02"))
+	}
+	event := existing[0]
+	if opts.dryRun {
+		fmt.Printf("Dry run, would update %s on %s:\n", event.Id, wfh.EventDate(event))
+		printChange("summary", event.Summary, patch.Summary)
+		printChange("color", event.ColorId, patch.ColorId)
+		printChange("description", event.Description, patch.Description)
+		return nil
+	}
+	event, err = client.Patch(event.Id, patch)
+	if err != nil {
+		return fmt.Errorf("client.Patch: %w", err)
+	}
+	fmt.Printf("Event updated: %s\nLink %s\n", event.Summary, event.HtmlLink)
+	return nil
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+// importICS books a WFH day for every day covered by an event in an iCalendar file.
+// Days that already have a WFH event are skipped.
+func importICS(client *wfh.Client, config Config, opts options) error {
+	f, err := os.Open(opts.importFile)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("os.Open: %w", err)
 	}
 	defer f.Close() // nolint: errcheck
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
+	events, err := parseICS(f, config.Location())
 	if err != nil {
-		log.Fatalf("Unable to decode token: %v", err)
+		return fmt.Errorf("parseICS: %w", err)
 	}
-	return tok, err
+	if len(events) == 0 {
+		fmt.Println("No events found.")
+		return nil
+	}
+	r := wfh.Range{From: events[0].days[0], To: events[0].days[0]}
+	for _, event := range events {
+		for _, day := range event.days {
+			if day.Before(r.From) {
+				r.From = day
+			}
+			if day.After(r.To) {
+				r.To = day
+			}
+		}
+	}
+	existing, err := client.FindWFH(r, config.DefaultMessage)
+	if err != nil {
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	booked := make(map[string]bool)
+	for _, item := range existing {
+		booked[wfh.EventDate(item)] = true
+	}
+	created, skipped := 0, 0
+	for _, event := range events {
+		bookOpts := bookOptions(opts)
+		if event.summary != "" {
+			bookOpts.Message = event.summary
+		}
+		for _, day := range event.days {
+			date := day.Format("2006-01-02")
+			if booked[date] {
+				fmt.Printf("Skipping %s, already booked\n", date)
+				skipped++
+				continue
+			}
+			// a single day is booked as asked, longer events only on working days.
+			if !config.isWorkingDay(day.Weekday()) && len(event.days) > 1 {
+				fmt.Printf("Skipping %s, not a working day\n", date)
+				skipped++
+				continue
+			}
+			if name, ok := config.holiday(day); ok && len(event.days) > 1 {
+				fmt.Printf("Skipping %s, %s\n", date, name)
+				skipped++
+				continue
+			}
+			if !opts.force {
+				err := checkHorizon(config, day)
+				if err != nil {
+					return err
+				}
+				err = checkNotice(config, day)
+				if err != nil {
+					return err
+				}
+			}
+			booked[date] = true
+			if opts.dryRun {
+				fmt.Printf("Dry run, would book %s: %s\n", date, bookOpts.Message)
+				created++
+				continue
+			}
+			bookOpts.ColorID = config.dayColor(opts, day)
+			if opts.colorFromMessage {
+				// the event's summary, not -message.
+				bookOpts.ColorID = config.messageColor(bookOpts.Message)
+			}
+			dayOpts, err := withDescription(config, bookOpts, day)
+			if err != nil {
+				return fmt.Errorf("withDescription: %w", err)
+			}
+			event, err := client.Book(day, dayOpts)
+			if err != nil {
+				return fmt.Errorf("client.Book(%s): %w", date, err)
+			}
+			afterBooking(config, opts, event)
+			fmt.Printf("Booked %s: %s\n", date, bookOpts.Message)
+			created++
+		}
+	}
+	fmt.Printf("Imported %d day(s), skipped %d\n", created, skipped)
+	return nil
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) error {
-	f, err := os.Create(path)
+// recolor changes the color of all WFH events in the requested range.
+func recolor(client *wfh.Client, config Config, opts options) error {
+	existing, err := client.FindWFH(wfh.Range{From: opts.from, To: opts.to}, config.DefaultMessage)
 	if err != nil {
-		return fmt.Errorf("os.Create: %w", err)
+		return fmt.Errorf("client.FindWFH: %w", err)
 	}
+	colorID := strconv.Itoa(opts.color)
+	var todo []*calendar.Event
+	for _, item := range existing {
+		if item.ColorId != colorID {
+			todo = append(todo, item)
+		}
+	}
+	if len(todo) == 0 {
+		fmt.Println("No WFH events to recolor.")
+		return nil
+	}
+	if !opts.force && !confirm(fmt.Sprintf("Recolor %d WFH event(s) between %s and %s to color %d?",
+		len(todo), opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02"), opts.color)) {
+		return fmt.Errorf("aborted by user")
+	}
+	updated := 0
+	for _, item := range todo {
+		_, err := client.Patch(item.Id, &calendar.Event{ColorId: colorID})
+		if err != nil {
+			fmt.Printf("Updated %d of %d events\n", updated, len(todo))
+			return fmt.Errorf("client.Patch: %w", err)
+		}
+		updated++
+	}
+	fmt.Printf("Updated %d events\n", updated)
+	return nil
+}
 
-	err = json.NewEncoder(f).Encode(token)
+// migrateSummary tags the untagged events in the requested range whose summary is
+// -migrate-summary, ignoring case, as WFH events, so they're found without relying on
+// the summary. With -message, the summary is changed to it as well.
+func migrateSummary(client *wfh.Client, opts options) error {
+	items, err := client.List(wfh.Range{From: opts.from, To: opts.to}, wfh.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("json.NewEncoder.Encode: %w", err)
+		return fmt.Errorf("client.List: %w", err)
+	}
+	var todo []*calendar.Event
+	for _, item := range items {
+		if item.ExtendedProperties != nil && item.ExtendedProperties.Private[wfh.MarkerKey] != "" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(item.Summary), opts.migrate) {
+			todo = append(todo, item)
+		}
+	}
+	if len(todo) == 0 {
+		fmt.Printf("No untagged events titled %q.\n", opts.migrate)
+		return nil
+	}
+	for _, item := range todo {
+		if opts.message != "" && opts.message != item.Summary {
+			fmt.Printf("  %s %q -> %q\n", wfh.EventDate(item), item.Summary, opts.message)
+		} else {
+			fmt.Printf("  %s %q\n", wfh.EventDate(item), item.Summary)
+		}
+	}
+	if opts.dryRun {
+		fmt.Printf("Dry run, would tag %d event(s) as WFH\n", len(todo))
+		return nil
+	}
+	if !opts.force && !confirm(fmt.Sprintf("Tag these %d event(s) as WFH?", len(todo))) {
+		return fmt.Errorf("aborted by user")
+	}
+	patch := &calendar.Event{
+		// private properties are merged, others on the event are kept.
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{wfh.MarkerKey: wfh.MarkerHome},
+		},
+		Summary: opts.message,
+	}
+	for i, item := range todo {
+		_, err := client.Patch(item.Id, patch)
+		if err != nil {
+			fmt.Printf("Tagged %d of %d events\n", i, len(todo))
+			return fmt.Errorf("client.Patch: %w", err)
+		}
+	}
+	fmt.Printf("Tagged %d events\n", len(todo))
+	return nil
+}
+
+// archive moves the WFH events between -from and -to to -to-calendar: each is copied
+// there, and only deleted once the copy is made. A failed event is reported and stays
+// where it is, the others are still moved.
+func archive(client *wfh.Client, backend wfh.Backend, config Config, opts options, configPath string) error {
+	target, err := resolveCalendar(backend, configPath, config.calendarID(opts.toCalendar))
+	if err != nil {
+		return fmt.Errorf("resolveCalendar(%s): %w", opts.toCalendar, err)
+	}
+	if target == opts.calendarID {
+		return fmt.Errorf("the events are in %s already", calendarName(config, target))
+	}
+	existing, err := client.FindWFH(wfh.Range{From: opts.from, To: opts.to}, config.DefaultMessage)
+	if err != nil {
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	if len(existing) == 0 {
+		fmt.Printf("No WFH events between %s and %s.\n", opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02"))
+		return nil
+	}
+	for _, item := range existing {
+		fmt.Printf("  %s %q\n", wfh.EventDate(item), item.Summary)
+	}
+	if opts.dryRun {
+		fmt.Printf("Dry run, would move %d event(s) to %s\n", len(existing), calendarName(config, target))
+		return nil
 	}
-	err = f.Close()
+	if !opts.force && !confirm(fmt.Sprintf("Move these %d event(s) to %s?", len(existing), calendarName(config, target))) {
+		return fmt.Errorf("aborted by user")
+	}
+	// the copies should do, but a mass deletion gets a backup all the same.
+	err = newBackup(config).add(opts.calendarID, existing...)
 	if err != nil {
-		return fmt.Errorf("f.Close: %w", err)
+		return fmt.Errorf("backup: %w", err)
+	}
+	failed := 0
+	for _, item := range existing {
+		date := wfh.EventDate(item)
+		copied, err := client.CopyTo(target, item)
+		if err != nil {
+			fmt.Printf("Unable to copy %s %q, left it in place: %v\n", date, item.Summary, err)
+			failed++
+			continue
+		}
+		err = client.Delete(item.Id)
+		if err != nil {
+			// don't leave it in both calendars.
+			undoErr := backend.Delete(target, copied.Id)
+			if undoErr != nil {
+				fmt.Printf("Unable to delete %s %q after copying it, it's in both calendars now: %v\n", date, item.Summary, err)
+			} else {
+				fmt.Printf("Unable to delete %s %q, left it in place: %v\n", date, item.Summary, err)
+			}
+			failed++
+			continue
+		}
+		config.logEvent("deleted", item)
+	}
+	fmt.Printf("Moved %d of %d event(s) to %s\n", len(existing)-failed, len(existing), calendarName(config, target))
+	if failed > 0 {
+		return fmt.Errorf("%d event(s) weren't moved", failed)
 	}
 	return nil
 }
 
-func main() {
-	configPath := getConfigPath()
-	// Check if gconfig directory exists, if not, create it.
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		err := os.Mkdir(configPath, os.ModePerm)
+// printChange prints one line of an update diff. An empty new value leaves the field as it is.
+func printChange(field, old, new string) {
+	if new == "" || new == old {
+		fmt.Printf("  %-12s unchanged\n", field+":")
+		return
+	}
+	fmt.Printf("  %-12s %q -> %q\n", field+":", old, new)
+}
+
+// dryRun prints the event that would be booked.
+func dryRun(client *wfh.Client, config Config, opts options) error {
+	bookOpts := bookOptions(opts)
+	if opts.office {
+		bookOpts.Marker = wfh.MarkerOffice
+	}
+	if opts.explain {
+		fmt.Println(explainBooking(config, opts, bookingDays(config, opts), nil))
+	}
+	for _, day := range bookingDays(config, opts) {
+		dayOpts := bookOpts
+		if !opts.office {
+			dayOpts.ColorID = config.dayColor(opts, day)
+			dayOpts.Start, dayOpts.End = opts.eventTimes(day)
+			var err error
+			dayOpts, err = withDescription(config, dayOpts, day)
+			if err != nil {
+				return fmt.Errorf("withDescription: %w", err)
+			}
+		}
+		b, err := json.MarshalIndent(client.NewEvent(day, dayOpts), "", "  ")
 		if err != nil {
-			log.Fatalf("Unable to create config directory: %v", err)
+			return fmt.Errorf("json.MarshalIndent: %w", err)
 		}
+		fmt.Printf("Dry run, would create this event in calendar %s:\n%s\n", client.CalendarID(), b)
 	}
-	tokenPath := filepath.Join(configPath, "token.json")
+	return nil
+}
+
+// previewURL is Google Calendar's page for creating an event from URL parameters.
+const previewURL = "https://calendar.google.com/calendar/render"
+
+// printPreviewLinks prints a link per day that opens Google Calendar's event editor
+// prefilled with the event, so it can be reviewed before saving. Nothing is booked.
+func printPreviewLinks(config Config, opts options) error {
+	for _, day := range bookingDays(config, opts) {
+		bookOpts, err := withDescription(config, bookOptions(opts), day)
+		if err != nil {
+			return fmt.Errorf("withDescription: %w", err)
+		}
+		fmt.Println(previewLink(day, bookOpts, opts.calendarID))
+	}
+	return nil
+}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	gconfig, err := google.ConfigFromJSON(googleCredentials, calendar.CalendarEventsScope)
+// previewLink returns the link to create an all-day event on the day. The end date of
+// an all-day event is exclusive in the link.
+func previewLink(day time.Time, bookOpts wfh.BookOptions, calendarID string) string {
+	params := url.Values{
+		"action": {"TEMPLATE"},
+		"text":   {bookOpts.Message},
+		"dates":  {day.Format("20060102") + "/" + day.AddDate(0, 0, 1).Format("20060102")},
+	}
+	if bookOpts.Description != "" {
+		params.Set("details", bookOpts.Description)
+	}
+	if calendarID != "" {
+		params.Set("src", calendarID)
+	}
+	return previewURL + "?" + params.Encode()
+}
+
+// appendNote adds a timestamped line to the description of the day's WFH event.
+func appendNote(client *wfh.Client, config Config, opts options) error {
+	existing, err := client.FindWFH(wfh.Day(opts.date), config.DefaultMessage)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to gconfig: %v", err)
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("no WFH event found on %s", opts.date.Format("2006-01-02"))
+	}
+	event := existing[0]
+	line := fmt.Sprintf("[%s] %s", config.now().Format("2006-01-02 15:04"), opts.appendNote)
+	description := line
+	if event.Description != "" {
+		description = event.Description + "\n" + line
 	}
-	calService := getClient(gconfig, tokenPath)
-	// load the config file:
-	config, err := getConfig(configPath)
+	event, err = client.Patch(event.Id, &calendar.Event{Description: description})
 	if err != nil {
-		log.Fatalf("Unable to load config file: %v", err)
+		return fmt.Errorf("client.Patch: %w", err)
+	}
+	fmt.Printf("Note added to %s\nLink %s\n", event.Summary, event.HtmlLink)
+	return nil
+}
+
+// backfill walks the last few working days and offers to book WFH on the days that
+// don't have a WFH event.
+func backfill(client *wfh.Client, config Config, opts options) error {
+	days := config.BackfillDays
+	if days <= 0 {
+		days = defaultBackfillDays
+	}
+	today := config.now()
+	var missing []time.Time
+	for day := today.AddDate(0, 0, -1); len(missing) < days; day = day.AddDate(0, 0, -1) {
+		if !config.isWorkingDay(day.Weekday()) {
+			continue
+		}
+		if name, ok := config.holiday(day); ok {
+			fmt.Printf("Skipping %s, %s\n", day.Format("2006-01-02"), name)
+			continue
+		}
+		missing = append([]time.Time{day}, missing...)
 	}
-	listAction, date, message, err := parseArgs(config.DefaultMessage)
+	existing, err := client.FindWFH(wfh.Range{From: missing[0], To: missing[len(missing)-1]}, config.DefaultMessage)
 	if err != nil {
-		fmt.Printf("while parsing arguments and flags: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("client.FindWFH: %w", err)
 	}
-	if listAction {
-		// just list the events and then exit.
-		listEvents(calService, config, date)
-		os.Exit(0)
-	}
-	// pick a random number from 1 to 11:
-	colorId := rand.Intn(11) + 1
-	event := &calendar.Event{
-		ColorId: strconv.Itoa(colorId),
-		Summary: message,
-		Start: &calendar.EventDateTime{
-			Date:     date.Format("2006-01-02"),
-			TimeZone: "UTC",
-		},
-		End: &calendar.EventDateTime{
-			Date:     date.Format("2006-01-02"),
-			TimeZone: "UTC",
-		},
+	booked := make(map[string]bool)
+	for _, item := range existing {
+		booked[wfh.EventDate(item)] = true
+	}
+	for _, day := range missing {
+		if booked[day.Format("2006-01-02")] {
+			continue
+		}
+		if !confirm(fmt.Sprintf("No WFH booked on %s. Book it?", day.Format("Mon 2006-01-02"))) {
+			continue
+		}
+		bookOpts := wfh.BookOptions{Message: config.DefaultMessage, ColorID: config.dayColor(opts, day), Booker: opts.booker, Properties: opts.properties}
+		bookOpts, err := withDescription(config, bookOpts, day)
+		if err != nil {
+			return fmt.Errorf("withDescription: %w", err)
+		}
+		event, err := client.Book(day, bookOpts)
+		if err != nil {
+			return fmt.Errorf("client.Book: %w", err)
+		}
+		afterBooking(config, opts, event)
+		fmt.Printf("Event created: %s\nLink %s\n", event.Summary, event.HtmlLink)
 	}
+	return nil
+}
 
-	event, err = calService.Events.Insert(config.CalendarID, event).Do()
+// countMonth prints the number of WFH days in the requested month. For the current
+// month it also says how many of them are behind us.
+func countMonth(w io.Writer, lister eventLister, config Config, opts options) error {
+	items, err := lister.List(wfh.Range{From: opts.from, To: opts.to}, wfh.ListOptions{})
 	if err != nil {
-		log.Fatalf("Unable to create event. %v\n", err)
+		return fmt.Errorf("List: %w", err)
 	}
-	fmt.Printf("Event created: %s\nLink %s\n", event.Summary, event.HtmlLink)
+	existing := wfh.FilterWFH(items, config.DefaultMessage, config.MatchMode)
+	today := config.now().Format("2006-01-02")
+	days := make(map[string]bool)
+	soFar := 0
+	for _, item := range existing {
+		date := wfh.EventDate(item)
+		if days[date] {
+			continue
+		}
+		days[date] = true
+		if date <= today {
+			soFar++
+		}
+	}
+	_, _ = fmt.Fprintf(w, "WFH days in %s: %d", opts.from.Format("January 2006"), len(days))
+	if today >= opts.from.Format("2006-01-02") && today <= opts.to.Format("2006-01-02") {
+		_, _ = fmt.Fprintf(w, " (%d so far)", soFar)
+	}
+	_, _ = fmt.Fprintln(w)
+	return nil
 }
 
-// listEvents lists the events for the given date.
-func listEvents(service *calendar.Service, config Config, date time.Time) {
-	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
-	endOfDay := time.Date(date.Year(), date.Month(), date.Day(), 23, 0, 0, 0, time.Local)
-	fmt.Printf("listing events for %s to %s\n", startOfDay.Format(time.RFC3339), endOfDay.Format(time.RFC3339))
-	events, err := service.Events.List(config.CalendarID).
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(startOfDay.Format(time.RFC3339)).
-		TimeMax(endOfDay.Format(time.RFC3339)).
-		OrderBy("startTime").
-		Do()
+// weekdaySummary prints how many WFH events fall on each weekday in the requested range.
+func weekdaySummary(w io.Writer, lister eventLister, config Config, opts options) error {
+	items, err := lister.List(wfh.Range{From: opts.from, To: opts.to}, wfh.ListOptions{})
 	if err != nil {
-		log.Fatalf("Unable to retrieve next ten of the user's events: %v", err)
+		return fmt.Errorf("List: %w", err)
 	}
-	if len(events.Items) == 0 {
-		fmt.Println("No events found.")
-	} else {
-		fmt.Println("Events:")
-		for _, item := range events.Items {
-			timeString := "(all day)"
-			if item.Start.DateTime != "" {
-				timeString = fmt.Sprintf("(%v --> %v)", item.Start.DateTime, item.End.DateTime)
+	existing := wfh.FilterWFH(items, config.DefaultMessage, config.MatchMode)
+	var counts [7]int
+	for _, item := range existing {
+		date, err := time.Parse("2006-01-02", wfh.EventDate(item))
+		if err != nil {
+			continue
+		}
+		counts[date.Weekday()]++
+	}
+	_, _ = fmt.Fprintf(w, "WFH by weekday, %s to %s:\n", opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02"))
+	// start the week on Monday.
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		if counts[day] == 0 && !config.isWorkingDay(day) {
+			// days off are left out, unless booked anyway.
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s: %3d %s\n", day.String()[:3], counts[day], strings.Repeat("#", counts[day]))
+	}
+	return nil
+}
+
+// conflictReport prints the days in the requested range with both a WFH event and an
+// event mentioning one of conflict_keywords, with the summaries of those events.
+func conflictReport(w io.Writer, lister eventLister, config Config, opts options) error {
+	if len(config.ConflictKeywords) == 0 {
+		return fmt.Errorf("no conflict_keywords configured, add them to config.json")
+	}
+	items, err := lister.List(wfh.Range{From: opts.from, To: opts.to}, wfh.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	wfhDays := make(map[string]bool)
+	for _, item := range wfh.FilterWFH(items, config.DefaultMessage, config.MatchMode) {
+		for _, date := range coveredDays(item) {
+			wfhDays[date] = true
+		}
+	}
+	from, to := opts.from.Format("2006-01-02"), opts.to.Format("2006-01-02")
+	conflicts := make(map[string][]string)
+	for _, item := range items {
+		if !config.conflicting(item) {
+			continue
+		}
+		for _, date := range coveredDays(item) {
+			// multi-day events can reach outside the range.
+			if wfhDays[date] && date >= from && date <= to {
+				conflicts[date] = append(conflicts[date], strconv.Quote(item.Summary))
 			}
-			fmt.Printf("%v %s [%s]\n", item.Summary, timeString, shortEmail(item.Creator.Email))
 		}
 	}
+	if len(conflicts) == 0 {
+		_, _ = fmt.Fprintf(w, "No conflicts, %s to %s.\n", from, to)
+		return nil
+	}
+	dates := make([]string, 0, len(conflicts))
+	for date := range conflicts {
+		dates = append(dates, date)
+	}
+	slices.Sort(dates)
+	_, _ = fmt.Fprintf(w, "WFH days with conflicts, %s to %s:\n", from, to)
+	for _, date := range dates {
+		_, _ = fmt.Fprintf(w, "%s: %s\n", date, strings.Join(conflicts[date], ", "))
+	}
+	return nil
 }
 
-func shortEmail(email string) string {
-	atIndex := len(email)
-	for i, c := range email {
-		if c == '@' {
-			atIndex = i
-			break
+// allCalendarsStatus prints, for each configured calendar, whether it has a WFH event on the date.
+func allCalendarsStatus(backend wfh.Backend, config Config, opts options) error {
+	names := make([]string, 0, len(config.Calendars))
+	for name := range config.Calendars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no calendars configured, add a \"calendars\" map to config.json")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "CALENDAR\tSTATUS\tSUMMARY\n")
+	for _, name := range names {
+		calendarID, err := resolveCalendar(backend, config.dir, config.Calendars[name].ID)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s\terror\t%v\n", name, err)
+			continue
+		}
+		client := wfh.NewBackendClient(backend, calendarID, config.clientOptions(config.calendarLocation(name))...)
+		event, err := client.Booked(opts.date, config.DefaultMessage)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s\terror\t%v\n", name, err)
+			continue
+		}
+		if event == nil {
+			_, _ = fmt.Fprintf(w, "%s\tnot booked\t\n", name)
+			continue
 		}
+		_, _ = fmt.Fprintf(w, "%s\tbooked\t%s\n", name, event.Summary)
 	}
-	return email[:atIndex]
+	return w.Flush()
+}
 
+// restoreEvents puts back events deleted as part of a failed operation.
+func restoreEvents(client *wfh.Client, events []*calendar.Event) {
+	err := client.Restore(events)
+	if err != nil {
+		log.Printf("Unable to restore deleted events: %v", err)
+	}
 }
 
-func getConfig(path string) (Config, error) {
-	var config Config
-	configPath := filepath.Join(path, "config.json")
-	b, err := os.ReadFile(configPath)
+// checkTimeZone warns if the configured time zone differs from the calendar's own. The
+// calendar's time zone is looked up the first time and cached in the config directory.
+func checkTimeZone(client *wfh.Client, config Config, configPath string) {
+	if config.Timezone == "" {
+		return
+	}
+	cache := loadCalendarCache(configPath)
+	cached := cache[client.CalendarID()]
+	if cached.TimeZone == "" {
+		tz, err := client.TimeZone()
+		if errors.Is(err, wfh.ErrNotSupported) {
+			return
+		}
+		if err != nil {
+			log.Printf("Unable to check the time zone of calendar %s: %v", client.CalendarID(), err)
+			return
+		}
+		cached.TimeZone = tz
+		cache[client.CalendarID()] = cached
+		err = cache.save(configPath)
+		if err != nil {
+			log.Printf("Unable to save calendar cache: %v", err)
+		}
+	}
+	if cached.TimeZone != config.Timezone {
+		fmt.Printf("Warning: configured timezone %q differs from the calendar's time zone %q.\n"+
+			"Events may show up on the wrong day. Set \"timezone\" to %q in config.json,\n"+
+			"or change the calendar's time zone in Google Calendar.\n",
+			config.Timezone, cached.TimeZone, cached.TimeZone)
+	}
+}
+
+// printConfig writes the config as wfh sees it, after defaults, with secrets redacted,
+// and the files it uses. Nothing is looked up, so it works without logging in.
+func printConfig(w io.Writer, config Config, opts options, configPath string) error {
+	out := struct {
+		ConfigFile         string `json:"config_file"`
+		BaseConfigFile     string `json:"base_config_file,omitempty"`
+		TokenFile          string `json:"token_file"`
+		ServiceAccountFile string `json:"service_account_file,omitempty"`
+		CalendarID         string `json:"calendar_id"`
+		Message            string `json:"message"`
+		Timezone           string `json:"timezone"`
+		Config             Config `json:"config"`
+	}{
+		ConfigFile:         filepath.Join(configPath, "config.json"),
+		BaseConfigFile:     config.baseFile,
+		TokenFile:          tokenFile(config, configPath),
+		ServiceAccountFile: serviceAccountFile(config),
+		CalendarID:         opts.calendarID,
+		Message:            opts.message,
+		Timezone:           config.Location().String(),
+		Config:             config.redacted(),
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
-		return Config{}, fmt.Errorf("os.ReadFile(%s): %w", configPath, err)
+		return fmt.Errorf("json.MarshalIndent: %w", err)
 	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
 
-	err = json.Unmarshal(b, &config)
+// resolveCalendar turns a calendar name into its ID, so the config can say "Work"
+// rather than an opaque ID. Anything that isn't the name of exactly one calendar is taken
+// to be an ID. The calendar list is cached in calendars.json, and only fetched again for
+// names the cache doesn't know.
+func resolveCalendar(backend wfh.Backend, configPath string, name string) (string, error) {
+	cache := loadCalendarCache(configPath)
+	if id, ok := cache.lookup(name); ok {
+		return id, nil
+	}
+	calendars, err := backend.Calendars()
+	if err != nil {
+		return "", fmt.Errorf("backend.Calendars: %w", err)
+	}
+	var matches []string
+	for _, cal := range calendars {
+		cached := cache[cal.Id]
+		cached.Summary = cal.Summary
+		cache[cal.Id] = cached
+		if cal.Summary == name {
+			matches = append(matches, cal.Id)
+		}
+	}
+	if _, ok := cache[name]; ok {
+		// an ID, whatever the calendars are called.
+		matches = []string{name}
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%d calendars are named %q, use the calendar ID instead: %s",
+			len(matches), name, strings.Join(matches, ", "))
+	}
+	id := name
+	if len(matches) == 1 {
+		id = matches[0]
+	}
+	// remember IDs that aren't in the calendar list too, so they aren't looked up every run.
+	if _, ok := cache[id]; !ok {
+		cache[id] = cachedCalendar{}
+	}
+	err = cache.save(configPath)
 	if err != nil {
-		return Config{}, fmt.Errorf("json.Unmarshal(%s): %w", configPath, err)
+		log.Printf("Unable to save calendar cache: %v", err)
 	}
+	return id, nil
+}
 
-	return config, nil
+// pickCalendar lets the user choose one of their calendars, and offers to save the
+// choice in the config. It only works when there is someone at the terminal to ask.
+func pickCalendar(backend wfh.Backend, configPath string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("calendar_id is not set in the config and -calendar wasn't given")
+	}
+	calendars, err := backend.Calendars()
+	if err != nil {
+		return "", fmt.Errorf("backend.Calendars: %w", err)
+	}
+	if len(calendars) == 0 {
+		return "", fmt.Errorf("you have no calendars")
+	}
+	fmt.Println("No calendar configured. Your calendars:")
+	for i, cal := range calendars {
+		fmt.Printf("%3d. %s (%s)\n", i+1, cal.Summary, cal.Id)
+	}
+	fmt.Printf("Pick a calendar [1-%d]: ", len(calendars))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading answer: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(calendars) {
+		return "", fmt.Errorf("invalid choice %q", strings.TrimSpace(answer))
+	}
+	id := calendars[n-1].Id
+	if confirm("Save it as calendar_id in config.json?") {
+		err = saveCalendarID(configPath, id)
+		if err != nil {
+			log.Printf("Unable to save config: %v", err)
+		}
+	}
+	return id, nil
 }
 
-func parseArgs(defaultMessage string) (bool, time.Time, string, error) {
-	// Define flags for the date and message arguments with default values of empty strings.
-	dateFlag := flag.String("date", "", "Provide a date in the format YYYY-MM-DD")
-	messageFlag := flag.String("message", "", "Provide a custom message")
-	list := flag.Bool("list", false, "List all events")
+// atExit are run before wfh exits through exit or fatalf. os.Exit skips deferred calls.
+var atExit []func()
 
-	// Parse the flags
-	flag.Parse()
-	// Check if there are any non-flag arguments and fail if there are
-	if len(flag.Args()) > 0 {
-		return false, time.Time{}, "", fmt.Errorf("unexpected non-flag arguments detected")
+// exit runs atExit and exits with code.
+func exit(code int) {
+	for _, f := range atExit {
+		f()
 	}
+	os.Exit(code)
+}
 
-	// Parse the date if provided
-	var parsedDate time.Time
-	if *dateFlag != "" {
-		var err error
-		parsedDate, err = time.Parse("2006-01-02", *dateFlag)
-		if err != nil {
-			// use today's date if the provided date is invalid
-			parsedDate = time.Now()
-		}
-	} else {
-		// use today's date if no date is provided
-		parsedDate = time.Now()
+// fatalf is log.Fatalf, running atExit first.
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	exit(1)
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a pipe or file.
+// /dev/null is a character device too, and what services and cron jobs often get.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
 	}
-	if *list {
-		return true, parsedDate, "", nil
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(fi, null) {
+		return false
 	}
-	var message string
-	if *messageFlag != "" {
-		message = *messageFlag
-	} else {
-		message = defaultMessage
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks the user a yes/no question on stdin. Anything but yes means no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
 	}
-	return false, parsedDate, message, nil
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
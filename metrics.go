@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// metricsStdout is the -metrics file that prints the metrics on stdout instead, when
+// wfh exits.
+const metricsStdout = "-"
+
+// metrics counts what wfh did to the calendar, for the Prometheus node_exporter textfile
+// collector. The file is rewritten after every API call rather than on exit, as not all
+// errors end wfh through exit.
+type metrics struct {
+	path    string
+	created int
+	failed  int
+	// latency sums up the time spent in each API call, by method.
+	latency map[string]*latencySum
+}
+
+type latencySum struct {
+	seconds float64
+	count   int
+}
+
+func newMetrics(path string) *metrics {
+	m := &metrics{path: path, latency: make(map[string]*latencySum)}
+	if path == metricsStdout {
+		atExit = append(atExit, func() {
+			_, _ = fmt.Print(m.format())
+		})
+		return m
+	}
+	m.write()
+	return m
+}
+
+// observe records an API call. Failed calls that would have changed an event count as
+// failed events.
+func (m *metrics) observe(method string, start time.Time, err error, changesEvent bool) {
+	l, ok := m.latency[method]
+	if !ok {
+		l = &latencySum{}
+		m.latency[method] = l
+	}
+	l.seconds += time.Since(start).Seconds()
+	l.count++
+	if err != nil && changesEvent {
+		m.failed++
+	}
+	m.write()
+}
+
+// format returns the metrics in the Prometheus text format.
+func (m *metrics) format() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "# HELP wfh_events_created_total Events created in the calendar.\n")
+	_, _ = fmt.Fprintf(&b, "# TYPE wfh_events_created_total counter\n")
+	_, _ = fmt.Fprintf(&b, "wfh_events_created_total %d\n", m.created)
+	_, _ = fmt.Fprintf(&b, "# HELP wfh_events_failed_total Events that couldn't be created, changed or deleted.\n")
+	_, _ = fmt.Fprintf(&b, "# TYPE wfh_events_failed_total counter\n")
+	_, _ = fmt.Fprintf(&b, "wfh_events_failed_total %d\n", m.failed)
+	_, _ = fmt.Fprintf(&b, "# HELP wfh_api_latency_seconds Time spent in calendar API calls.\n")
+	_, _ = fmt.Fprintf(&b, "# TYPE wfh_api_latency_seconds summary\n")
+	methods := make([]string, 0, len(m.latency))
+	for method := range m.latency {
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	for _, method := range methods {
+		l := m.latency[method]
+		_, _ = fmt.Fprintf(&b, "wfh_api_latency_seconds_sum{method=%q} %g\n", method, l.seconds)
+		_, _ = fmt.Fprintf(&b, "wfh_api_latency_seconds_count{method=%q} %d\n", method, l.count)
+	}
+	return b.String()
+}
+
+// write replaces the metrics file. The collector may read it at any time, so it's
+// written to a temporary file first and renamed into place.
+func (m *metrics) write() {
+	if m.path == metricsStdout {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".wfh-metrics-*")
+	if err != nil {
+		log.Printf("Unable to write metrics: %v", err)
+		return
+	}
+	_, err = tmp.WriteString(m.format())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// CreateTemp makes the file private, the collector may run as another user.
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Printf("Unable to write metrics: %v", err)
+	}
+}
+
+// metricsBackend records metrics for the calls to the backend it wraps.
+type metricsBackend struct {
+	wfh.Backend
+	m *metrics
+}
+
+func (b metricsBackend) Insert(calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	start := time.Now()
+	event, err := b.Backend.Insert(calendarID, event)
+	if err == nil {
+		b.m.created++
+	}
+	b.m.observe("insert", start, err, true)
+	return event, err
+}
+
+func (b metricsBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	start := time.Now()
+	event, err := b.Backend.Get(calendarID, eventID)
+	b.m.observe("get", start, err, false)
+	return event, err
+}
+
+func (b metricsBackend) List(calendarID string, q wfh.ListQuery) (*calendar.Events, error) {
+	start := time.Now()
+	events, err := b.Backend.List(calendarID, q)
+	b.m.observe("list", start, err, false)
+	return events, err
+}
+
+func (b metricsBackend) Delete(calendarID, eventID string) error {
+	start := time.Now()
+	err := b.Backend.Delete(calendarID, eventID)
+	b.m.observe("delete", start, err, true)
+	return err
+}
+
+func (b metricsBackend) Patch(calendarID, eventID string, patch *calendar.Event) (*calendar.Event, error) {
+	start := time.Now()
+	event, err := b.Backend.Patch(calendarID, eventID, patch)
+	b.m.observe("patch", start, err, true)
+	return event, err
+}
+
+func (b metricsBackend) TimeZone(calendarID string) (string, error) {
+	start := time.Now()
+	tz, err := b.Backend.TimeZone(calendarID)
+	b.m.observe("timezone", start, err, false)
+	return tz, err
+}
+
+func (b metricsBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	start := time.Now()
+	calendars, err := b.Backend.Calendars()
+	b.m.observe("calendars", start, err, false)
+	return calendars, err
+}
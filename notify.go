@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// notifyTimeout keeps a slow webhook from holding up wfh.
+const notifyTimeout = 10 * time.Second
+
+// afterBooking records a booked WFH event in the event log and the history, and tells
+// the team about it, unless -no-notify is given.
+func afterBooking(config Config, opts options, event *calendar.Event) {
+	config.logEvent("booked", event)
+	config.addHistory(opts.calendarID, event)
+	// a color given for this booking, or the day's own, leaves the cycle where it is.
+	if day, err := time.ParseInLocation("2006-01-02", wfh.EventDate(event), config.Location()); err == nil && config.usesColorCycle(opts, day) {
+		config.advanceColorCycle()
+	}
+	if opts.noNotify {
+		return
+	}
+	// a failed notification doesn't undo the booking, so it's only a warning.
+	if config.SlackWebhookURL != "" {
+		err := notifySlack(config.SlackWebhookURL, fmt.Sprintf("%s is WFH on %s", config.user(), wfh.EventDate(event)))
+		if err != nil {
+			log.Printf("Warning: unable to notify Slack: %v", err)
+		}
+	}
+	if config.Webhook != nil {
+		err := config.Webhook.call(webhookData{
+			Date:    wfh.EventDate(event),
+			Summary: event.Summary,
+			User:    config.user(),
+			Link:    event.HtmlLink,
+		})
+		if err != nil {
+			log.Printf("Warning: webhook %s failed: %v", config.Webhook.URL, err)
+		} else {
+			fmt.Printf("Webhook %s called\n", config.Webhook.URL)
+		}
+	}
+}
+
+// webhookData is what the webhook body template is executed with.
+type webhookData struct {
+	Date    string // YYYY-MM-DD
+	Summary string
+	User    string
+	Link    string
+}
+
+// webhookFuncs are available in webhook body templates. json quotes a value for use
+// in a JSON body, e.g. {"text": {{json .Summary}}}.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// call makes the webhook request. Any 2xx response is success.
+func (h *Webhook) call(data webhookData) error {
+	var body strings.Builder
+	err := h.body.Execute(&body, data)
+	if err != nil {
+		return fmt.Errorf("template.Execute: %w", err)
+	}
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, h.URL, strings.NewReader(body.String()))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	for name, value := range h.Headers {
+		req.Header.Set(name, value)
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// notifySlack posts a message to a Slack incoming webhook.
+func notifySlack(webhookURL, text string) error {
+	b, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("http.Post: %w", err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// user returns the name to use for the user, user from the config or $USER.
+func (c Config) user() string {
+	if c.User != "" {
+		return c.User
+	}
+	return os.Getenv("USER")
+}
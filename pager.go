@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// defaultScreenRows is the height of the terminal when $LINES doesn't say.
+const defaultScreenRows = 24
+
+// pagerCommand returns the command to page listings through: $PAGER, or less. An empty
+// $PAGER or cat means no pager.
+func pagerCommand() []string {
+	pager, ok := os.LookupEnv("PAGER")
+	if !ok {
+		pager = "less"
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 || args[0] == "cat" {
+		return nil
+	}
+	return args
+}
+
+// usePager reports whether the listing should go through the pager. Only listings shown
+// on a terminal are paged, not ones written to -out, exported, -raw JSON, or piped.
+func usePager(opts options) bool {
+	return opts.out == "" && opts.export == "" && !opts.raw && !opts.noPager && isTerminal(os.Stdout) && pagerCommand() != nil
+}
+
+// page shows the listing through the pager, like git does. Listings that fit on the
+// screen are written directly, as they are when the pager can't be started.
+func page(listing []byte) {
+	rows, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || rows <= 0 {
+		rows = defaultScreenRows
+	}
+	args := pagerCommand()
+	if args == nil || bytes.Count(listing, []byte("\n")) < rows {
+		_, _ = os.Stdout.Write(listing)
+		return
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(listing)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		// quit when it fits on the screen after all, keep colors, and leave the
+		// listing on the screen.
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	err = cmd.Start()
+	if err != nil {
+		_, _ = os.Stdout.Write(listing)
+		return
+	}
+	err = cmd.Wait()
+	if err != nil {
+		log.Printf("Pager %s failed: %v", args[0], err)
+	}
+}
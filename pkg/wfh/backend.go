@@ -0,0 +1,177 @@
+package wfh
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrNotSupported is returned by backends for operations their calendar API can't do.
+var ErrNotSupported = errors.New("not supported by this calendar backend")
+
+// ErrNotFound is returned by Get for an event that doesn't exist.
+var ErrNotFound = errors.New("event not found")
+
+// ErrConflict is returned by Insert for an event with the ID of one that exists, or
+// existed: Google keeps the IDs of deleted events.
+var ErrConflict = errors.New("an event with this ID exists")
+
+// ErrSyncTokenExpired is returned when the calendar no longer accepts a sync token. The
+// events have to be listed in full again.
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+// Backend is a calendar API. Events are passed as Google Calendar events whatever the
+// backend, so the rest of the package only has to deal with one representation.
+type Backend interface {
+	// Insert creates an event in the calendar.
+	Insert(calendarID string, event *calendar.Event) (*calendar.Event, error)
+	// Get returns one event by its ID, or ErrNotFound. Deleted events may be returned
+	// with Status "cancelled".
+	Get(calendarID, eventID string) (*calendar.Event, error)
+	// List returns a page of the events overlapping the query's span.
+	List(calendarID string, q ListQuery) (*calendar.Events, error)
+	// Delete deletes an event, or returns ErrNotFound if it's gone already.
+	Delete(calendarID, eventID string) error
+	// Patch updates the fields set in patch on an event.
+	Patch(calendarID, eventID string, patch *calendar.Event) (*calendar.Event, error)
+	// TimeZone returns the IANA time zone of the calendar.
+	TimeZone(calendarID string) (string, error)
+	// Calendars returns the calendars the user has access to.
+	Calendars() ([]*calendar.CalendarListEntry, error)
+}
+
+// ListQuery asks a Backend for one page of events.
+type ListQuery struct {
+	Start time.Time
+	End   time.Time
+	// OrderBy is OrderStartTime or OrderUpdated. Empty leaves the order to the backend.
+	OrderBy string
+	// MaxResults caps the size of the page. Zero leaves it to the backend.
+	MaxResults int64
+	// PageToken is the NextPageToken of the previous page, empty for the first one.
+	PageToken string
+	// SyncToken asks for the events changed since the list that returned it, deleted
+	// ones included, instead of the events in the span.
+	SyncToken string
+	// Text is a free-text search, see ListOptions. It isn't used with SyncToken.
+	Text string
+}
+
+// Google returns a Backend for the Google Calendar API.
+func Google(service *calendar.Service) Backend {
+	return &googleBackend{service: service}
+}
+
+type googleBackend struct {
+	service *calendar.Service
+}
+
+func (g *googleBackend) Insert(calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	call := g.service.Events.Insert(calendarID, event)
+	if len(event.Attachments) > 0 {
+		// without it, the API drops the attachments.
+		call = call.SupportsAttachments(true)
+	}
+	created, err := call.Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return nil, fmt.Errorf("Events.Insert(%s): %w", event.Id, ErrConflict)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Events.Insert: %w", err)
+	}
+	return created, nil
+}
+
+func (g *googleBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	event, err := g.service.Events.Get(calendarID, eventID).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Events.Get(%s): %w", eventID, err)
+	}
+	return event, nil
+}
+
+func (g *googleBackend) List(calendarID string, q ListQuery) (*calendar.Events, error) {
+	call := g.service.Events.List(calendarID).
+		SingleEvents(true).
+		PageToken(q.PageToken)
+	if q.SyncToken != "" {
+		// a sync token can't be combined with a time span or order, and always
+		// includes deleted events.
+		call = call.SyncToken(q.SyncToken)
+	} else {
+		call = call.ShowDeleted(false).
+			TimeMin(q.Start.Format(time.RFC3339)).
+			TimeMax(q.End.Format(time.RFC3339))
+		if q.OrderBy != "" {
+			call = call.OrderBy(q.OrderBy)
+		}
+		if q.Text != "" {
+			call = call.Q(q.Text)
+		}
+	}
+	if q.MaxResults > 0 {
+		call = call.MaxResults(q.MaxResults)
+	}
+	events, err := call.Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusGone && q.SyncToken != "" {
+		return nil, ErrSyncTokenExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Events.List: %w", err)
+	}
+	return events, nil
+}
+
+func (g *googleBackend) Delete(calendarID, eventID string) error {
+	err := g.service.Events.Delete(calendarID, eventID).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+		return fmt.Errorf("Events.Delete(%s): %w", eventID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("Events.Delete(%s): %w", eventID, err)
+	}
+	return nil
+}
+
+func (g *googleBackend) Patch(calendarID, eventID string, patch *calendar.Event) (*calendar.Event, error) {
+	event, err := g.service.Events.Patch(calendarID, eventID, patch).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Events.Patch(%s): %w", eventID, err)
+	}
+	return event, nil
+}
+
+func (g *googleBackend) TimeZone(calendarID string) (string, error) {
+	cal, err := g.service.Calendars.Get(calendarID).Do()
+	if err != nil {
+		return "", fmt.Errorf("Calendars.Get(%s): %w", calendarID, err)
+	}
+	return cal.TimeZone, nil
+}
+
+func (g *googleBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	var calendars []*calendar.CalendarListEntry
+	pageToken := ""
+	for {
+		list, err := g.service.CalendarList.List().PageToken(pageToken).Do()
+		if err != nil {
+			return nil, fmt.Errorf("CalendarList.List: %w", err)
+		}
+		calendars = append(calendars, list.Items...)
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			return calendars, nil
+		}
+	}
+}
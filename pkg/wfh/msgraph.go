@@ -0,0 +1,428 @@
+package wfh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+const graphURL = "https://graph.microsoft.com/v1.0"
+
+// graphProperties are the private extended properties kept on Graph events.
+var graphProperties = []string{MarkerKey, BookerKey}
+
+// graphPropertyID returns the ID of the Graph extended property holding a private
+// property. The GUID is wfh's own property set, it only has to stay the same.
+func graphPropertyID(key string) string {
+	return "String {5d3f6c1e-8a4b-4f0e-9c77-2b1f0e6a9d41} Name " + key
+}
+
+// graphDateTime is how Graph passes times: local to the given time zone, without offset.
+const graphDateTime = "2006-01-02T15:04:05"
+
+// MicrosoftGraph returns a Backend for Microsoft 365 calendars through the Graph API.
+// The client must be authenticated for the Calendars.ReadWrite permission. Use
+// "primary" as the calendar ID for the user's default calendar.
+//
+// Graph has no color IDs, so colors are ignored, and only one reminder is kept.
+func MicrosoftGraph(client *http.Client) Backend {
+	return MicrosoftGraphAt(client, graphURL)
+}
+
+// MicrosoftGraphAt is MicrosoftGraph with another base URL than Graph v1.0, like a
+// national cloud or a fake Graph server in tests.
+func MicrosoftGraphAt(client *http.Client, baseURL string) Backend {
+	return &graphBackend{client: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+type graphBackend struct {
+	client  *http.Client
+	baseURL string
+}
+
+type graphEvent struct {
+	ID                            string          `json:"id,omitempty"`
+	Subject                       string          `json:"subject,omitempty"`
+	Body                          *graphBody      `json:"body,omitempty"`
+	Location                      *graphLocation  `json:"location,omitempty"`
+	Start                         *graphTime      `json:"start,omitempty"`
+	End                           *graphTime      `json:"end,omitempty"`
+	IsAllDay                      bool            `json:"isAllDay,omitempty"`
+	ShowAs                        string          `json:"showAs,omitempty"`
+	Sensitivity                   string          `json:"sensitivity,omitempty"`
+	IsReminderOn                  *bool           `json:"isReminderOn,omitempty"`
+	ReminderMinutesBeforeStart    *int64          `json:"reminderMinutesBeforeStart,omitempty"`
+	WebLink                       string          `json:"webLink,omitempty"`
+	LastModifiedDateTime          string          `json:"lastModifiedDateTime,omitempty"`
+	Organizer                     *graphRecipient `json:"organizer,omitempty"`
+	OnlineMeeting                 *graphMeeting   `json:"onlineMeeting,omitempty"`
+	SingleValueExtendedProperties []graphProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+type graphMeeting struct {
+	JoinURL string `json:"joinUrl"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphRecipient struct {
+	EmailAddress struct {
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+type graphProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+type graphError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// calendarPath returns the Graph path of a calendar.
+func calendarPath(calendarID string) string {
+	if calendarID == "primary" {
+		return "/me/calendar"
+	}
+	return "/me/calendars/" + url.PathEscape(calendarID)
+}
+
+// do sends a request to Graph and decodes the response into out, unless it's nil.
+// Paths not starting with / are full URLs and used as they are, that's how Graph pages.
+func (g *graphBackend) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	u := path
+	if strings.HasPrefix(u, "/") {
+		u = g.baseURL + path
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		var gerr graphError
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, &gerr) == nil && gerr.Error.Message != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, gerr.Error.Code, gerr.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("decoding %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// expandProperties asks Graph to include our properties with the events it returns.
+func expandProperties() string {
+	var filters []string
+	for _, key := range graphProperties {
+		filters = append(filters, "id eq '"+graphPropertyID(key)+"'")
+	}
+	return "singleValueExtendedProperties($filter=" + strings.Join(filters, " or ") + ")"
+}
+
+func (g *graphBackend) Insert(calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	in, err := toGraphEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	var out graphEvent
+	err = g.do(http.MethodPost, calendarPath(calendarID)+"/events", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	// the response doesn't include extended properties unless expanded.
+	out.SingleValueExtendedProperties = in.SingleValueExtendedProperties
+	return fromGraphEvent(out), nil
+}
+
+// List doesn't support sync tokens. Graph has delta queries instead, which work
+// differently, so every sync is a full one.
+func (g *graphBackend) List(calendarID string, q ListQuery) (*calendar.Events, error) {
+	if q.SyncToken != "" {
+		return nil, ErrNotSupported
+	}
+	path := q.PageToken
+	if path == "" {
+		orderBy := "start/dateTime"
+		if q.OrderBy == OrderUpdated {
+			orderBy = "lastModifiedDateTime"
+		}
+		params := url.Values{
+			"startDateTime": {q.Start.Format(time.RFC3339)},
+			"endDateTime":   {q.End.Format(time.RFC3339)},
+			"$orderby":      {orderBy},
+			"$expand":       {expandProperties()},
+		}
+		if q.MaxResults > 0 {
+			params.Set("$top", strconv.FormatInt(q.MaxResults, 10))
+		}
+		path = calendarPath(calendarID) + "/calendarView?" + params.Encode()
+	}
+	var page struct {
+		Value    []graphEvent `json:"value"`
+		NextLink string       `json:"@odata.nextLink"`
+	}
+	err := g.do(http.MethodGet, path, nil, &page)
+	if err != nil {
+		return nil, err
+	}
+	events := &calendar.Events{NextPageToken: page.NextLink}
+	for _, item := range page.Value {
+		event := fromGraphEvent(item)
+		// calendarView can't be searched, the page is filtered instead.
+		if q.Text != "" && !MatchesText(event, q.Text) {
+			continue
+		}
+		events.Items = append(events.Items, event)
+	}
+	return events, nil
+}
+
+func (g *graphBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	var out graphEvent
+	err := g.do(http.MethodGet, calendarPath(calendarID)+"/events/"+url.PathEscape(eventID)+"?$expand="+url.QueryEscape(expandProperties()), nil, &out)
+	if err != nil {
+		return nil, err
+	}
+	return fromGraphEvent(out), nil
+}
+
+func (g *graphBackend) Delete(calendarID, eventID string) error {
+	return g.do(http.MethodDelete, calendarPath(calendarID)+"/events/"+url.PathEscape(eventID), nil, nil)
+}
+
+func (g *graphBackend) Patch(calendarID, eventID string, patch *calendar.Event) (*calendar.Event, error) {
+	in, err := toGraphEvent(patch)
+	if err != nil {
+		return nil, err
+	}
+	var out graphEvent
+	err = g.do(http.MethodPatch, calendarPath(calendarID)+"/events/"+url.PathEscape(eventID)+"?$expand="+url.QueryEscape(expandProperties()), in, &out)
+	if err != nil {
+		return nil, err
+	}
+	return fromGraphEvent(out), nil
+}
+
+// TimeZone isn't supported, Graph calendars have no time zone of their own.
+func (g *graphBackend) TimeZone(calendarID string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (g *graphBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	var calendars []*calendar.CalendarListEntry
+	path := "/me/calendars"
+	for path != "" {
+		var page struct {
+			Value []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		err := g.do(http.MethodGet, path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, cal := range page.Value {
+			calendars = append(calendars, &calendar.CalendarListEntry{Id: cal.ID, Summary: cal.Name})
+		}
+		path = page.NextLink
+	}
+	return calendars, nil
+}
+
+// toGraphEvent converts the fields of event that Graph has a place for. Fields left
+// empty are left out, so the result also works as a patch.
+func toGraphEvent(event *calendar.Event) (graphEvent, error) {
+	if len(event.Attachments) > 0 {
+		// Graph attaches uploaded files, not links to Google Drive.
+		return graphEvent{}, fmt.Errorf("attachments: %w", ErrNotSupported)
+	}
+	if len(event.Recurrence) > 0 {
+		// Graph has its own recurrence pattern, not RRULEs.
+		return graphEvent{}, fmt.Errorf("recurrence: %w", ErrNotSupported)
+	}
+	out := graphEvent{Subject: event.Summary}
+	if event.Description != "" {
+		out.Body = &graphBody{ContentType: "text", Content: event.Description}
+	}
+	if event.Location != "" {
+		out.Location = &graphLocation{DisplayName: event.Location}
+	}
+	switch event.Transparency {
+	case "transparent":
+		out.ShowAs = "free"
+	case "opaque":
+		out.ShowAs = "busy"
+	}
+	if event.Start != nil && event.Start.Date != "" {
+		// Google all-day events end on the same day, Graph's end at midnight after.
+		day, err := time.Parse("2006-01-02", event.Start.Date)
+		if err != nil {
+			return graphEvent{}, fmt.Errorf("invalid start date %q: %w", event.Start.Date, err)
+		}
+		out.IsAllDay = true
+		out.Start = &graphTime{DateTime: day.Format(graphDateTime), TimeZone: event.Start.TimeZone}
+		out.End = &graphTime{DateTime: day.AddDate(0, 0, 1).Format(graphDateTime), TimeZone: event.Start.TimeZone}
+	} else if event.Start != nil && event.End != nil {
+		var err error
+		out.Start, err = toGraphTime(event.Start.DateTime)
+		if err != nil {
+			return graphEvent{}, err
+		}
+		out.End, err = toGraphTime(event.End.DateTime)
+		if err != nil {
+			return graphEvent{}, err
+		}
+	}
+	if event.Reminders != nil {
+		on := len(event.Reminders.Overrides) > 0
+		out.IsReminderOn = &on
+		if on {
+			out.ReminderMinutesBeforeStart = &event.Reminders.Overrides[0].Minutes
+		}
+	}
+	if event.ExtendedProperties != nil {
+		// all of them are written, but only graphProperties are read back.
+		keys := make([]string, 0, len(event.ExtendedProperties.Private))
+		for key := range event.ExtendedProperties.Private {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			out.SingleValueExtendedProperties = append(out.SingleValueExtendedProperties,
+				graphProperty{ID: graphPropertyID(key), Value: event.ExtendedProperties.Private[key]})
+		}
+	}
+	return out, nil
+}
+
+// fromGraphEvent converts a Graph event to the Google representation.
+func fromGraphEvent(item graphEvent) *calendar.Event {
+	event := &calendar.Event{
+		Id:       item.ID,
+		Summary:  item.Subject,
+		HtmlLink: item.WebLink,
+		Updated:  item.LastModifiedDateTime,
+	}
+	if item.Body != nil {
+		event.Description = item.Body.Content
+	}
+	if item.Location != nil {
+		event.Location = item.Location.DisplayName
+	}
+	switch item.ShowAs {
+	case "free":
+		event.Transparency = "transparent"
+	case "":
+	default:
+		event.Transparency = "opaque"
+	}
+	if item.Sensitivity != "" && item.Sensitivity != "normal" {
+		event.Visibility = item.Sensitivity
+	}
+	if item.Organizer != nil {
+		event.Creator = &calendar.EventCreator{Email: item.Organizer.EmailAddress.Address}
+	}
+	if item.OnlineMeeting != nil && item.OnlineMeeting.JoinURL != "" {
+		// Teams meetings, as a video entry point like other non-Meet conferences.
+		event.ConferenceData = &calendar.ConferenceData{
+			EntryPoints: []*calendar.EntryPoint{{EntryPointType: "video", Uri: item.OnlineMeeting.JoinURL}},
+		}
+	}
+	event.Start = fromGraphTime(item.Start, item.IsAllDay)
+	event.End = fromGraphTime(item.End, item.IsAllDay)
+	for _, prop := range item.SingleValueExtendedProperties {
+		for _, key := range graphProperties {
+			if !strings.EqualFold(prop.ID, graphPropertyID(key)) {
+				continue
+			}
+			if event.ExtendedProperties == nil {
+				event.ExtendedProperties = &calendar.EventExtendedProperties{Private: map[string]string{}}
+			}
+			event.ExtendedProperties.Private[key] = prop.Value
+		}
+	}
+	return event
+}
+
+// toGraphTime converts an RFC 3339 time. Graph wants it without the offset, so it's
+// passed in UTC.
+func toGraphTime(dateTime string) (*graphTime, error) {
+	t, err := time.Parse(time.RFC3339, dateTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q: %w", dateTime, err)
+	}
+	return &graphTime{DateTime: t.UTC().Format(graphDateTime), TimeZone: "UTC"}, nil
+}
+
+// fromGraphTime converts a Graph time. All-day events get a date, like in Google.
+func fromGraphTime(t *graphTime, allDay bool) *calendar.EventDateTime {
+	if t == nil || len(t.DateTime) < len(graphDateTime) {
+		return nil
+	}
+	if allDay {
+		return &calendar.EventDateTime{Date: t.DateTime[:len("2006-01-02")], TimeZone: t.TimeZone}
+	}
+	// Graph returns UTC unless asked otherwise, and leaves out the fractional seconds
+	// only sometimes.
+	loc, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	parsed, err := time.ParseInLocation(graphDateTime, t.DateTime[:len(graphDateTime)], loc)
+	if err != nil {
+		return nil
+	}
+	return &calendar.EventDateTime{DateTime: parsed.Format(time.RFC3339), TimeZone: t.TimeZone}
+}
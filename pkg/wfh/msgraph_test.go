@@ -0,0 +1,141 @@
+package wfh
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeGraph is a Graph server with one calendar, keeping the events it's given.
+type fakeGraph struct {
+	t      *testing.T
+	events []graphEvent
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/me/calendar/events":
+		var event graphEvent
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err != nil {
+			f.t.Errorf("decoding the event: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		event.ID = "event" + strconv.Itoa(len(f.events))
+		f.events = append(f.events, event)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(event)
+	case r.Method == http.MethodGet && r.URL.Path == "/me/calendar/calendarView":
+		if r.URL.Query().Get("startDateTime") == "" || r.URL.Query().Get("endDateTime") == "" {
+			f.t.Errorf("calendarView without a span: %s", r.URL.RawQuery)
+		}
+		// one event per page, to exercise the paging.
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		var out struct {
+			Value    []graphEvent `json:"value"`
+			NextLink string       `json:"@odata.nextLink,omitempty"`
+		}
+		if page < len(f.events) {
+			out.Value = f.events[page : page+1]
+		}
+		if page+1 < len(f.events) {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			out.NextLink = "http://" + r.Host + r.URL.Path + "?" + q.Encode()
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"code": "ErrorItemNotFound", "message": "not found"}}`))
+	}
+}
+
+func newFakeGraph(t *testing.T) (*fakeGraph, *Client) {
+	fake := &fakeGraph{t: t}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, NewBackendClient(MicrosoftGraphAt(srv.Client(), srv.URL), "primary", WithLocation(time.UTC))
+}
+
+func TestGraphBook(t *testing.T) {
+	fake, client := newFakeGraph(t)
+	day := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	event, err := client.Book(day, BookOptions{Message: "WFH", Location: "Home", Booker: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("Book: %v", err)
+	}
+	if event.Id != "event0" || event.Summary != "WFH" || event.Location != "Home" {
+		t.Errorf("Book returned %+v", event)
+	}
+	if got := EventDate(event); got != "2024-06-03" {
+		t.Errorf("Book returned an event on %s, want 2024-06-03", got)
+	}
+	if got := event.ExtendedProperties.Private[MarkerKey]; got != MarkerHome {
+		t.Errorf("marker is %q, want %q", got, MarkerHome)
+	}
+	if len(fake.events) != 1 {
+		t.Fatalf("the server got %d events, want 1", len(fake.events))
+	}
+	sent := fake.events[0]
+	if !sent.IsAllDay || sent.Start.DateTime != "2024-06-03T00:00:00" || sent.End.DateTime != "2024-06-04T00:00:00" {
+		t.Errorf("sent an event from %+v to %+v, all day %t", sent.Start, sent.End, sent.IsAllDay)
+	}
+	if len(sent.SingleValueExtendedProperties) != 2 {
+		t.Errorf("sent properties %+v, want the booker and the marker", sent.SingleValueExtendedProperties)
+	}
+}
+
+func TestGraphBookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": {"code": "ErrorAccessDenied", "message": "Access is denied."}}`))
+	}))
+	defer srv.Close()
+	client := NewBackendClient(MicrosoftGraphAt(srv.Client(), srv.URL), "primary")
+	_, err := client.Book(time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), BookOptions{Message: "WFH"})
+	if err == nil {
+		t.Fatal("Book succeeded on a 403")
+	}
+	want := "POST /me/calendar/events: ErrorAccessDenied: Access is denied."
+	if err.Error() != want {
+		t.Errorf("Book returned %q, want %q", err, want)
+	}
+}
+
+func TestGraphList(t *testing.T) {
+	_, client := newFakeGraph(t)
+	for _, day := range []int{3, 4, 5} {
+		_, err := client.Book(time.Date(2024, 6, day, 0, 0, 0, 0, time.UTC), BookOptions{Message: "WFH"})
+		if err != nil {
+			t.Fatalf("Book: %v", err)
+		}
+	}
+	r := Range{From: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 6, 7, 0, 0, 0, 0, time.UTC)}
+	items, err := client.List(r, ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var dates []string
+	for _, item := range items {
+		dates = append(dates, EventDate(item))
+		if !IsWFH(item, "WFH", "") {
+			t.Errorf("%s isn't recognized as WFH", EventDate(item))
+		}
+	}
+	want := []string{"2024-06-03", "2024-06-04", "2024-06-05"}
+	if !slices.Equal(dates, want) {
+		t.Errorf("List returned %v, want %v", dates, want)
+	}
+	items, err = client.List(r, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("List with a limit of 2 returned %d events", len(items))
+	}
+}
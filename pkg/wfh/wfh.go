@@ -0,0 +1,527 @@
+// Package wfh books, lists and deletes "Work From Home" events in a Google calendar,
+// or in a Microsoft 365 calendar through the Graph API.
+//
+// The package doesn't deal with authentication. Construct a *calendar.Service with
+// whatever credentials fit your application and hand it to NewClient, or hand an
+// authenticated *http.Client to MicrosoftGraph and the Backend to NewBackendClient.
+package wfh
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Private extended property used to tag the events we create, so they can be found again.
+const (
+	MarkerKey    = "wfh"
+	MarkerHome   = "home"
+	MarkerOffice = "office"
+)
+
+// BookerKey is the private extended property naming who an event was booked for, when
+// that isn't the account that created it, e.g. a shared service account.
+const BookerKey = "booker"
+
+// How the summary of an event without a marker is matched against the WFH message.
+// The empty MatchMode is MatchCaseInsensitive.
+const (
+	// MatchExact needs the summary to be the message.
+	MatchExact = "exact"
+	// MatchCaseInsensitive ignores case, and differences in whitespace.
+	MatchCaseInsensitive = "ci"
+	// MatchContains finds the message anywhere in the summary, ignoring case and
+	// whitespace like MatchCaseInsensitive.
+	MatchContains = "contains"
+)
+
+// ErrEmptySummary is returned by Book for an event without a summary, which would show
+// up blank in the calendar.
+var ErrEmptySummary = errors.New("the event has no summary")
+
+// Google calendar event colors are numbered 1 to MaxColorID.
+const MaxColorID = 11
+
+// Client operates on a single calendar.
+type Client struct {
+	backend    Backend
+	calendarID string
+	location   *time.Location
+	// owner is set when events get IDs made from their day, see WithDeterministicIDs.
+	owner         string
+	deterministic bool
+	matchMode     string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithLocation sets the time zone used to resolve days and to create events.
+// The default is time.Local.
+func WithLocation(loc *time.Location) Option {
+	return func(c *Client) {
+		c.location = loc
+	}
+}
+
+// WithDeterministicIDs makes Book give all-day events an ID made from the owner, the
+// date and the marker, so Booked can fetch the day's event instead of listing the day.
+// owner tells apart the people booking in a shared calendar. Only Google takes IDs
+// from the client.
+func WithDeterministicIDs(owner string) Option {
+	return func(c *Client) {
+		c.owner = owner
+		c.deterministic = true
+	}
+}
+
+// WithMatchMode sets how FindWFH and Booked match summaries of events without a
+// marker, one of MatchExact, MatchCaseInsensitive and MatchContains.
+func WithMatchMode(mode string) Option {
+	return func(c *Client) {
+		c.matchMode = mode
+	}
+}
+
+// NewClient returns a Client for the given Google calendar.
+func NewClient(service *calendar.Service, calendarID string, opts ...Option) *Client {
+	return NewBackendClient(Google(service), calendarID, opts...)
+}
+
+// NewBackendClient returns a Client for the given calendar in any backend.
+func NewBackendClient(backend Backend, calendarID string, opts ...Option) *Client {
+	c := &Client{backend: backend, calendarID: calendarID, location: time.Local}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ListCalendars returns the calendars in the user's Google calendar list.
+func ListCalendars(service *calendar.Service) ([]*calendar.CalendarListEntry, error) {
+	return Google(service).Calendars()
+}
+
+// CalendarID returns the ID of the calendar the client operates on.
+func (c *Client) CalendarID() string {
+	return c.calendarID
+}
+
+// TimeZone returns the time zone of the calendar, as configured in the calendar itself.
+func (c *Client) TimeZone() (string, error) {
+	return c.backend.TimeZone(c.calendarID)
+}
+
+// timeZoneName returns the IANA name of the client's location. time.Local has no
+// usable name, so events fall back to UTC there.
+func (c *Client) timeZoneName() string {
+	if c.location == time.Local {
+		return "UTC"
+	}
+	return c.location.String()
+}
+
+// BookOptions controls how an event is booked.
+type BookOptions struct {
+	// Message is the summary of the event.
+	Message string
+	// Marker tags the event. Defaults to MarkerHome.
+	Marker string
+	// ColorID is the Google calendar color, 1-MaxColorID. Zero picks a random color.
+	ColorID int
+	// Description is the longer text of the event.
+	Description string
+	// Location is where the event takes place, like "Home" or a city.
+	Location string
+	// Reminders replace the calendar's default reminders when set.
+	Reminders []*calendar.EventReminder
+	// Transparency is "transparent" for events that don't block time, or "opaque" for
+	// those that do. Empty leaves it to the calendar.
+	Transparency string
+	// Booker is stored in the BookerKey property when set.
+	Booker string
+	// Properties are more private extended properties, for other tools to read. They
+	// can't replace MarkerKey or BookerKey.
+	Properties map[string]string
+	// Attachments are Google Drive files linked from the event.
+	Attachments []*calendar.EventAttachment
+	// Recurrence holds RRULE, EXDATE and RDATE lines making the event recurring.
+	Recurrence []string
+	// Start and End make a timed event instead of one lasting the whole date. Zero
+	// means all day.
+	Start time.Time
+	End   time.Time
+}
+
+// Book creates an all-day event on the given date. The event must have a summary.
+func (c *Client) Book(date time.Time, opts BookOptions) (*calendar.Event, error) {
+	if strings.TrimSpace(opts.Message) == "" {
+		return nil, ErrEmptySummary
+	}
+	if !opts.Start.IsZero() && !opts.End.After(opts.Start) {
+		return nil, fmt.Errorf("the event ends at %s, before it starts at %s",
+			opts.End.Format(time.RFC3339), opts.Start.Format(time.RFC3339))
+	}
+	event := c.NewEvent(date, opts)
+	if event.Id == "" {
+		return c.backend.Insert(c.calendarID, event)
+	}
+	created, err := c.backend.Insert(c.calendarID, event)
+	if !errors.Is(err, ErrConflict) {
+		return created, err
+	}
+	// the ID is taken. If by a deleted event, that one is brought back instead.
+	existing, err := c.backend.Get(c.calendarID, event.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Get(%s): %w", event.Id, err)
+	}
+	if existing.Status != "cancelled" {
+		return nil, fmt.Errorf("%s is already booked: %w", date.Format("2006-01-02"), ErrConflict)
+	}
+	event.Status = "confirmed"
+	return c.backend.Patch(c.calendarID, event.Id, event)
+}
+
+// EventID returns the ID Book gives the all-day event on the date with the marker, when
+// the client uses deterministic IDs. Google IDs are limited to 0-9 and a-v, which the
+// markers and a hex hash fit.
+func (c *Client) EventID(date time.Time, marker string) string {
+	sum := sha256.Sum256([]byte(c.owner))
+	return fmt.Sprintf("%s%s%x", marker, date.Format("20060102"), sum[:8])
+}
+
+// Booked returns the WFH event on the day, or nil if there is none. With deterministic
+// IDs it's a single fetch by ID, and only events booked that way are found. Otherwise
+// the day is listed, and message is used like in FindWFH.
+func (c *Client) Booked(date time.Time, message string) (*calendar.Event, error) {
+	if !c.deterministic {
+		existing, err := c.FindWFH(Day(date), message)
+		if err != nil || len(existing) == 0 {
+			return nil, err
+		}
+		return existing[0], nil
+	}
+	event, err := c.backend.Get(c.calendarID, c.EventID(date, MarkerHome))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Status == "cancelled" {
+		return nil, nil
+	}
+	return event, nil
+}
+
+// NewEvent builds the event Book would create, without creating it.
+func (c *Client) NewEvent(date time.Time, opts BookOptions) *calendar.Event {
+	colorID := opts.ColorID
+	if colorID == 0 {
+		// pick a random number from 1 to 11:
+		colorID = rand.Intn(MaxColorID) + 1
+	}
+	marker := opts.Marker
+	if marker == "" {
+		marker = MarkerHome
+	}
+	private := make(map[string]string, len(opts.Properties)+2)
+	for key, value := range opts.Properties {
+		private[key] = value
+	}
+	private[MarkerKey] = marker
+	if opts.Booker != "" {
+		private[BookerKey] = opts.Booker
+	}
+	var reminders *calendar.EventReminders
+	if opts.Reminders != nil {
+		reminders = &calendar.EventReminders{
+			Overrides: opts.Reminders,
+			// UseDefault is false, which would otherwise be left out.
+			ForceSendFields: []string{"UseDefault"},
+		}
+	}
+	var id string
+	if c.deterministic && opts.Start.IsZero() && len(opts.Recurrence) == 0 {
+		id = c.EventID(date, marker)
+	}
+	return &calendar.Event{
+		Id:           id,
+		Attachments:  opts.Attachments,
+		Recurrence:   opts.Recurrence,
+		Reminders:    reminders,
+		Transparency: opts.Transparency,
+		ColorId:      strconv.Itoa(colorID),
+		Summary:      opts.Message,
+		Description:  opts.Description,
+		Location:     opts.Location,
+		Start:        eventTime(date, opts.Start, c.timeZoneName()),
+		End:          eventTime(date, opts.End, c.timeZoneName()),
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: private,
+		},
+	}
+}
+
+// eventTime returns the date of an all-day event, or t for a timed one.
+func eventTime(date, t time.Time, timeZone string) *calendar.EventDateTime {
+	if t.IsZero() {
+		return &calendar.EventDateTime{Date: date.Format("2006-01-02"), TimeZone: timeZone}
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339), TimeZone: timeZone}
+}
+
+// Range is a span of days. Both ends are inclusive.
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Day returns a Range covering a single day.
+func Day(date time.Time) Range {
+	return Range{From: date, To: date}
+}
+
+// Orders supported by List.
+const (
+	OrderStartTime = "startTime"
+	OrderUpdated   = "updated"
+)
+
+// ListOptions controls how events are listed.
+type ListOptions struct {
+	// Limit caps the number of events returned. Zero means no limit.
+	Limit int
+	// OrderBy is OrderStartTime (the default) or OrderUpdated.
+	OrderBy string
+	// Reverse returns the events in descending order. The API can't do this, so all
+	// events in the range are fetched and sorted locally before Limit is applied.
+	Reverse bool
+	// Text only returns events mentioning it, in the summary, description or location
+	// among others. Google searches on the server, other backends filter the pages.
+	Text string
+}
+
+// maxPageSize is the largest page Events.List will return. Backends with smaller pages
+// just return fewer events per page.
+const maxPageSize = 2500
+
+// List returns the events in the range, ordered as requested.
+func (c *Client) List(r Range, opts ListOptions) ([]*calendar.Event, error) {
+	orderBy := opts.OrderBy
+	if orderBy == "" {
+		orderBy = OrderStartTime
+	}
+	if orderBy != OrderStartTime && orderBy != OrderUpdated {
+		return nil, fmt.Errorf("unsupported order %q", orderBy)
+	}
+	if opts.Reverse {
+		items, err := c.List(r, ListOptions{OrderBy: orderBy, Text: opts.Text})
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(items)
+		if opts.Limit > 0 && len(items) > opts.Limit {
+			items = items[:opts.Limit]
+		}
+		return items, nil
+	}
+	start := time.Date(r.From.Year(), r.From.Month(), r.From.Day(), 0, 0, 0, 0, c.location)
+	end := time.Date(r.To.Year(), r.To.Month(), r.To.Day(), 0, 0, 0, 0, c.location).AddDate(0, 0, 1)
+	var items []*calendar.Event
+	pageToken := ""
+	for {
+		q := ListQuery{Start: start, End: end, OrderBy: orderBy, PageToken: pageToken, Text: opts.Text}
+		if opts.Limit > 0 {
+			// don't fetch more than we're going to return.
+			q.MaxResults = int64(min(opts.Limit-len(items), maxPageSize))
+		}
+		events, err := c.backend.List(c.calendarID, q)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, events.Items...)
+		pageToken = events.NextPageToken
+		if opts.Limit > 0 && len(items) >= opts.Limit {
+			return items[:opts.Limit], nil
+		}
+		if pageToken == "" {
+			return items, nil
+		}
+	}
+}
+
+// SyncAll returns all events in the range, and a token to get the changes since with
+// SyncChanges. The token is empty if the backend doesn't support incremental syncs.
+func (c *Client) SyncAll(r Range) ([]*calendar.Event, string, error) {
+	start := time.Date(r.From.Year(), r.From.Month(), r.From.Day(), 0, 0, 0, 0, c.location)
+	end := time.Date(r.To.Year(), r.To.Month(), r.To.Day(), 0, 0, 0, 0, c.location).AddDate(0, 0, 1)
+	return c.sync(ListQuery{Start: start, End: end})
+}
+
+// SyncChanges returns the events changed since the sync that returned the token, and
+// a new token. Deleted events are included with Status "cancelled". Changes aren't
+// limited to the range of the first sync. If the token has expired, the error is
+// ErrSyncTokenExpired and SyncAll has to be used again.
+func (c *Client) SyncChanges(token string) ([]*calendar.Event, string, error) {
+	return c.sync(ListQuery{SyncToken: token})
+}
+
+// sync pages through a list. The sync token comes with the last page.
+func (c *Client) sync(q ListQuery) ([]*calendar.Event, string, error) {
+	var items []*calendar.Event
+	for {
+		events, err := c.backend.List(c.calendarID, q)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, events.Items...)
+		q.PageToken = events.NextPageToken
+		if q.PageToken == "" {
+			return items, events.NextSyncToken, nil
+		}
+	}
+}
+
+// FindWFH returns the WFH events in the range. message is the summary used to
+// recognize events booked before they were tagged with a marker.
+func (c *Client) FindWFH(r Range, message string) ([]*calendar.Event, error) {
+	items, err := c.List(r, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return FilterWFH(items, message, c.matchMode), nil
+}
+
+// FilterWFH returns the WFH events among items. See IsWFH.
+func FilterWFH(items []*calendar.Event, message, mode string) []*calendar.Event {
+	var found []*calendar.Event
+	for _, item := range items {
+		if IsWFH(item, message, mode) {
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+// MatchesText reports whether the summary, description or location of the event
+// contains text, ignoring case. It stands in for the search of the Google Calendar API
+// where there is none.
+func MatchesText(item *calendar.Event, text string) bool {
+	text = strings.ToLower(text)
+	for _, field := range []string{item.Summary, item.Description, item.Location} {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventDate returns the day an event starts on, as YYYY-MM-DD.
+func EventDate(item *calendar.Event) string {
+	if item.Start == nil {
+		return ""
+	}
+	if item.Start.Date != "" {
+		return item.Start.Date
+	}
+	t, err := time.Parse(time.RFC3339, item.Start.DateTime)
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// IsWFH reports whether the event is a WFH booking. Events carrying our marker are
+// matched on that; events created before the marker existed are matched on the summary,
+// as mode says.
+func IsWFH(item *calendar.Event, message, mode string) bool {
+	if item.ExtendedProperties != nil {
+		if marker, ok := item.ExtendedProperties.Private[MarkerKey]; ok {
+			return marker == MarkerHome
+		}
+	}
+	return MatchesSummary(item.Summary, message, mode)
+}
+
+// MatchesSummary reports whether summary matches message in the given MatchMode. An
+// empty message matches nothing.
+func MatchesSummary(summary, message, mode string) bool {
+	if mode == MatchExact {
+		return message != "" && summary == message
+	}
+	// "WFH ", "wfh" and "Working  from home" are what people type.
+	summary = strings.ToLower(strings.Join(strings.Fields(summary), " "))
+	message = strings.ToLower(strings.Join(strings.Fields(message), " "))
+	if message == "" {
+		return false
+	}
+	if mode == MatchContains {
+		return strings.Contains(summary, message)
+	}
+	return summary == message
+}
+
+// Get returns the event with the given ID.
+func (c *Client) Get(eventID string) (*calendar.Event, error) {
+	return c.backend.Get(c.calendarID, eventID)
+}
+
+// Delete deletes the event with the given ID.
+func (c *Client) Delete(eventID string) error {
+	return c.backend.Delete(c.calendarID, eventID)
+}
+
+// Patch updates the fields set in patch on the event with the given ID.
+func (c *Client) Patch(eventID string, patch *calendar.Event) (*calendar.Event, error) {
+	return c.backend.Patch(c.calendarID, eventID, patch)
+}
+
+// CopyTo creates a copy of the event in another calendar of the same backend, with an
+// ID of its own, and returns the copy.
+func (c *Client) CopyTo(calendarID string, item *calendar.Event) (*calendar.Event, error) {
+	return c.backend.Insert(calendarID, eventCopy(item))
+}
+
+// eventCopy returns what's needed to create the event again: what wfh books, without
+// the ID and what the calendar sets itself.
+func eventCopy(item *calendar.Event) *calendar.Event {
+	return &calendar.Event{
+		ColorId:            item.ColorId,
+		Summary:            item.Summary,
+		Description:        item.Description,
+		Location:           item.Location,
+		Transparency:       item.Transparency,
+		Start:              item.Start,
+		End:                item.End,
+		ExtendedProperties: item.ExtendedProperties,
+	}
+}
+
+// Restore re-creates events that were deleted, e.g. as part of a failed operation.
+// It returns the first error but keeps trying the remaining events.
+func (c *Client) Restore(events []*calendar.Event) error {
+	var firstErr error
+	for _, item := range events {
+		if c.deterministic && item.Id != "" {
+			// deleted events keep their ID, which Booked looks for, so they're undeleted.
+			_, err := c.backend.Patch(c.calendarID, item.Id, &calendar.Event{Status: "confirmed"})
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("restoring %q: %w", item.Summary, err)
+			}
+			continue
+		}
+		_, err := c.backend.Insert(c.calendarID, eventCopy(item))
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("restoring %q: %w", item.Summary, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,90 @@
+package wfh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// newFakeGoogle returns a client for a fake Google Calendar API served by handler.
+func newFakeGoogle(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	service, err := calendar.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("calendar.NewService: %v", err)
+	}
+	return NewClient(service, "primary", opts...)
+}
+
+func TestBookConflict(t *testing.T) {
+	day := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		status   string // of the event already holding the ID
+		wantErr  error
+		wantCall string // the last call made
+	}{
+		{"deleted event is brought back", "cancelled", nil, http.MethodPatch},
+		{"booked already", "confirmed", ErrConflict, http.MethodGet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id, lastCall string
+			client := newFakeGoogle(t, func(w http.ResponseWriter, r *http.Request) {
+				lastCall = r.Method
+				switch r.Method {
+				case http.MethodPost:
+					var event calendar.Event
+					_ = json.NewDecoder(r.Body).Decode(&event)
+					id = event.Id
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"error": {"code": 409, "message": "The requested identifier already exists."}}`))
+				case http.MethodGet:
+					if r.URL.Path != "/calendars/primary/events/"+id {
+						t.Errorf("got %s, want the event %s", r.URL.Path, id)
+					}
+					_ = json.NewEncoder(w).Encode(calendar.Event{Id: id, Status: tt.status})
+				case http.MethodPatch:
+					var event calendar.Event
+					_ = json.NewDecoder(r.Body).Decode(&event)
+					if event.Status != "confirmed" {
+						t.Errorf("the patch sets status %q, want confirmed", event.Status)
+					}
+					_ = json.NewEncoder(w).Encode(event)
+				}
+			}, WithLocation(time.UTC), WithDeterministicIDs("jane@example.com"))
+			event, err := client.Book(day, BookOptions{Message: "WFH"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Book returned %v, want %v", err, tt.wantErr)
+			}
+			if id != client.EventID(day, MarkerHome) {
+				t.Errorf("booked with ID %q, want %q", id, client.EventID(day, MarkerHome))
+			}
+			if lastCall != tt.wantCall {
+				t.Errorf("the last call was %s, want %s", lastCall, tt.wantCall)
+			}
+			if err == nil && event.Summary != "WFH" {
+				t.Errorf("Book returned %+v", event)
+			}
+		})
+	}
+}
+
+func TestBookEmptySummary(t *testing.T) {
+	// the backend isn't called, the event is refused before.
+	client := NewBackendClient(nil, "primary")
+	for _, message := range []string{"", " ", "\t\n"} {
+		_, err := client.Book(time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), BookOptions{Message: message})
+		if !errors.Is(err, ErrEmptySummary) {
+			t.Errorf("Book with message %q returned %v, want ErrEmptySummary", message, err)
+		}
+	}
+}
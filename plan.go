@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	calendar "google.golang.org/api/calendar/v3"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// planDays is how many working days -plan shows.
+const planDays = 7
+
+// plan shows the next working days, from today, and whether WFH is booked on them. On a
+// terminal the days can then be toggled: free days get booked, booked days cleared.
+func plan(client *wfh.Client, config Config, opts options) error {
+	today := config.now()
+	// days can be toggled, holidays are only shown.
+	var days, shown []time.Time
+	// working_days can't be empty, but a year is plenty to give up on finding any.
+	for day := today; len(days) < planDays && day.Before(today.AddDate(1, 0, 0)); day = day.AddDate(0, 0, 1) {
+		if !config.isWorkingDay(day.Weekday()) {
+			continue
+		}
+		shown = append(shown, day)
+		if _, ok := config.holiday(day); !ok {
+			days = append(days, day)
+		}
+	}
+	if len(days) == 0 {
+		return fmt.Errorf("no working days, check working_days")
+	}
+	existing, err := client.FindWFH(wfh.Range{From: days[0], To: days[len(days)-1]}, config.DefaultMessage)
+	if err != nil {
+		return fmt.Errorf("client.FindWFH: %w", err)
+	}
+	booked := make(map[string][]*calendar.Event)
+	for _, item := range existing {
+		booked[wfh.EventDate(item)] = append(booked[wfh.EventDate(item)], item)
+	}
+	n := 0
+	for _, day := range shown {
+		if name, ok := config.holiday(day); ok {
+			fmt.Printf("   %s  %s\n", day.Format("Mon 2006-01-02"), name)
+			continue
+		}
+		n++
+		items := booked[day.Format("2006-01-02")]
+		if len(items) > 0 {
+			fmt.Printf("%d  %s  [x] %s\n", n, day.Format("Mon 2006-01-02"), items[0].Summary)
+		} else {
+			fmt.Printf("%d  %s  [ ]\n", n, day.Format("Mon 2006-01-02"))
+		}
+	}
+	if !isTerminal(os.Stdin) {
+		return nil
+	}
+	fmt.Print("Toggle days by number, like 1 3, or press enter to leave them: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil
+	}
+	for _, field := range strings.Fields(answer) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > len(days) {
+			return fmt.Errorf("%q isn't one of the days, 1 to %d", field, len(days))
+		}
+		day := days[n-1]
+		items := booked[day.Format("2006-01-02")]
+		if len(items) > 0 {
+			for _, item := range items {
+				err := client.Delete(item.Id)
+				if err != nil {
+					return fmt.Errorf("client.Delete: %w", err)
+				}
+				config.logEvent("deleted", item)
+			}
+			fmt.Printf("Cleared %s\n", day.Format("Mon 2006-01-02"))
+			continue
+		}
+		if !opts.force {
+			err := checkNotice(config, day)
+			if err != nil {
+				return err
+			}
+		}
+		bookOpts := bookOptions(opts)
+		bookOpts.ColorID = config.dayColor(opts, day)
+		bookOpts, err = withDescription(config, bookOpts, day)
+		if err != nil {
+			return fmt.Errorf("withDescription: %w", err)
+		}
+		event, err := client.Book(day, bookOpts)
+		if err != nil {
+			return fmt.Errorf("client.Book: %w", err)
+		}
+		afterBooking(config, opts, event)
+		fmt.Printf("Booked %s: %s\n", day.Format("Mon 2006-01-02"), event.Summary)
+	}
+	return nil
+}
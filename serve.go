@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bookRequest is the body of a POST to /book. Everything is optional, an empty body
+// books today with the default message.
+type bookRequest struct {
+	// Date is YYYY-MM-DD or relative, like tomorrow or +2.
+	Date        string `json:"date"`
+	Message     string `json:"message"`
+	ColorID     int    `json:"color"`
+	Description string `json:"description"`
+}
+
+// bookResponse is the event /book created.
+type bookResponse struct {
+	ID      string `json:"id"`
+	Date    string `json:"date"`
+	Summary string `json:"summary"`
+	Link    string `json:"link"`
+}
+
+// serve runs wfh as a small HTTP service on addr. GET /healthz checks that the calendar
+// can be reached with the token, POST /book books a day.
+func serve(addr string, client *wfh.Client, config Config, opts options) error {
+	// one request at a time, the metrics and the event log aren't safe for concurrent use.
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// listing today fails if the token is no good or the calendar is gone.
+		_, err := client.List(wfh.Day(config.now()), wfh.ListOptions{Limit: 1})
+		if err != nil {
+			log.Printf("Health check failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/book", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var req bookRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req)
+		// an empty body books today with the defaults.
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		resp, status, err := serveBook(client, config, opts, req)
+		if err != nil {
+			log.Printf("Unable to book: %v", err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Serving on %s", addr)
+	return server.ListenAndServe()
+}
+
+// serveBook books the day asked for, the way booking on the command line would. The
+// status is the HTTP status to fail with.
+func serveBook(client *wfh.Client, config Config, opts options, req bookRequest) (bookResponse, int, error) {
+	today := config.now()
+	day := today
+	if req.Date != "" {
+		var err error
+		day, err = parseDate(req.Date, today)
+		if err != nil {
+			return bookResponse{}, http.StatusBadRequest, fmt.Errorf("invalid date: %w", err)
+		}
+	}
+	if !opts.force {
+		err := checkHorizon(config, day)
+		if err != nil {
+			return bookResponse{}, http.StatusBadRequest, err
+		}
+		err = checkNotice(config, day)
+		if err != nil {
+			return bookResponse{}, http.StatusBadRequest, err
+		}
+	}
+	err := checkColor(req.ColorID)
+	if err != nil {
+		return bookResponse{}, http.StatusBadRequest, err
+	}
+	bookOpts := bookOptions(opts)
+	bookOpts.ColorID = config.dayColor(opts, day)
+	if req.Message != "" {
+		bookOpts.Message = withEmoji(config.SummaryEmoji, config.normalizeSummary(req.Message))
+		if opts.colorFromMessage {
+			bookOpts.ColorID = config.messageColor(bookOpts.Message)
+		}
+	}
+	if req.ColorID != 0 {
+		bookOpts.ColorID = req.ColorID
+	}
+	if req.Description != "" {
+		bookOpts.Description = req.Description
+	}
+	bookOpts, err = withDescription(config, bookOpts, day)
+	if err != nil {
+		return bookResponse{}, http.StatusInternalServerError, fmt.Errorf("withDescription: %w", err)
+	}
+	event, err := client.Book(day, bookOpts)
+	if err != nil {
+		return bookResponse{}, http.StatusBadGateway, fmt.Errorf("client.Book: %w", err)
+	}
+	afterBooking(config, opts, event)
+	log.Printf("Event created: %s on %s", event.Summary, wfh.EventDate(event))
+	return bookResponse{
+		ID:      event.Id,
+		Date:    wfh.EventDate(event),
+		Summary: event.Summary,
+		Link:    event.HtmlLink,
+	}, http.StatusOK, nil
+}
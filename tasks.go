@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/perbu/wfh/pkg/wfh"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	tasks "google.golang.org/api/tasks/v1"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tasksTokenFile keeps the Google Tasks login apart from the calendar's, so only those
+// using -from-google-tasks are asked for access to their tasks.
+const tasksTokenFile = "tasks-token.json"
+
+// tasksService logs in to Google Tasks, through the browser the first time.
+func tasksService(configPath string, opts options) (*tasks.Service, error) {
+	gconfig, err := google.ConfigFromJSON(googleCredentials, tasks.TasksScope)
+	if err != nil {
+		return nil, fmt.Errorf("google.ConfigFromJSON: %w", err)
+	}
+	httpClient := getHTTPClient(gconfig, filepath.Join(configPath, tasksTokenFile), opts.authMode, opts.callbackPorts, opts.forceRefresh)
+	service, err := tasks.NewService(context.Background(), option.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("tasks.NewService: %w", err)
+	}
+	return service, nil
+}
+
+// taskDay returns the day a task is about: its due date, or else a date or day of the
+// week in its title. "WFH Thursday" is the next Thursday, or today on a Thursday.
+func taskDay(task *tasks.Task, today time.Time) (time.Time, bool) {
+	if len(task.Due) >= len("2006-01-02") {
+		// only the date of the due time means anything, Tasks has no times.
+		day, err := time.ParseInLocation("2006-01-02", task.Due[:len("2006-01-02")], today.Location())
+		if err == nil {
+			return day, true
+		}
+	}
+	for _, word := range strings.Fields(task.Title) {
+		word = strings.Trim(word, ".,;:!?()")
+		if day, err := parseDate(word, today); err == nil {
+			return day, true
+		}
+		if weekday, ok := parseWeekday(word); ok {
+			start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+			return start.AddDate(0, 0, (int(weekday)-int(start.Weekday())+7)%7), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// bookFromTasks books WFH on the days of the open tasks whose title matches
+// -from-google-tasks, and with -complete-tasks marks them done. Days already booked
+// aren't booked again, but their tasks are completed all the same.
+func bookFromTasks(client *wfh.Client, service *tasks.Service, config Config, opts options) error {
+	var matching []*tasks.Task
+	err := service.Tasks.List(opts.taskList).ShowCompleted(false).Pages(context.Background(), func(page *tasks.Tasks) error {
+		for _, task := range page.Items {
+			if opts.taskPattern.MatchString(task.Title) {
+				matching = append(matching, task)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("service.Tasks.List: %w", err)
+	}
+	if len(matching) == 0 {
+		fmt.Printf("No open tasks match %q\n", opts.taskPattern.String())
+		return nil
+	}
+	today := config.now()
+	booked, failed := 0, 0
+	for _, task := range matching {
+		day, ok := taskDay(task, today)
+		if !ok {
+			fmt.Printf("%q: skipped, no date in it\n", task.Title)
+			continue
+		}
+		if !opts.force {
+			err := checkHorizon(config, day)
+			if err == nil {
+				err = checkNotice(config, day)
+			}
+			if err != nil {
+				fmt.Printf("%q: skipped, %v\n", task.Title, err)
+				continue
+			}
+		}
+		result, err := bookTask(client, config, opts, day)
+		if err != nil {
+			fmt.Printf("%q: %s: failed: %v\n", task.Title, day.Format("Mon 2006-01-02"), err)
+			if opts.failFast {
+				return fmt.Errorf("booking %q failed", task.Title)
+			}
+			failed++
+			continue
+		}
+		booked++
+		if opts.completeTasks && !opts.dryRun {
+			_, err := service.Tasks.Patch(opts.taskList, task.Id, &tasks.Task{Status: "completed"}).Do()
+			if err != nil {
+				result += fmt.Sprintf(", but the task wasn't completed: %v", err)
+			} else {
+				result += ", task completed"
+			}
+		}
+		fmt.Printf("%q: %s: %s\n", task.Title, day.Format("Mon 2006-01-02"), result)
+	}
+	fmt.Printf("Booked %d of %d matching task(s)\n", booked, len(matching))
+	if failed > 0 {
+		return fmt.Errorf("%d task(s) failed", failed)
+	}
+	return nil
+}
+
+// bookTask books WFH on the day of a task, unless it's booked already, returning what
+// it did.
+func bookTask(client *wfh.Client, config Config, opts options, day time.Time) (string, error) {
+	existing, err := client.FindWFH(wfh.Day(day), config.DefaultMessage)
+	if err != nil {
+		return "", fmt.Errorf("client.FindWFH: %w", err)
+	}
+	if len(existing) > 0 {
+		return "already booked", nil
+	}
+	if opts.dryRun {
+		return "would book", nil
+	}
+	bookOpts := bookOptions(opts)
+	bookOpts.ColorID = config.dayColor(opts, day)
+	bookOpts, err = withDescription(config, bookOpts, day)
+	if err != nil {
+		return "", fmt.Errorf("withDescription: %w", err)
+	}
+	event, err := client.Book(day, bookOpts)
+	if err != nil {
+		return "", fmt.Errorf("client.Book: %w", err)
+	}
+	afterBooking(config, opts, event)
+	return fmt.Sprintf("booked %s", event.Summary), nil
+}